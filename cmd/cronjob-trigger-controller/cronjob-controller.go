@@ -17,17 +17,36 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/kubeless/cronjob-trigger/pkg/controller"
+	"github.com/kubeless/cronjob-trigger/pkg/scheduler"
 	cronjobtriggerutils "github.com/kubeless/cronjob-trigger/pkg/utils"
 	"github.com/kubeless/cronjob-trigger/pkg/version"
 	kubelessutils "github.com/kubeless/kubeless/pkg/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const leaseName = "cronjob-trigger-controller"
+
+var (
+	clusterDomain        string
+	executionMode        string
+	schedulerWorkers     int
+	leaderElect          bool
+	leaderElectNamespace string
+	orphanSweepPeriod    time.Duration
+	orphanSweepDryRun    bool
 )
 
 var rootCmd = &cobra.Command{
@@ -46,10 +65,20 @@ var rootCmd = &cobra.Command{
 			logrus.Fatalf("Cannot get Cronjob trigger API client: %v", err)
 		}
 
+		kubeCli := cronjobtriggerutils.GetClient()
+		triggerScheduler := scheduler.New(schedulerWorkers)
+
 		cronJobTriggerCfg := controller.CronJobTriggerConfig{
-			KubeCli:        cronjobtriggerutils.GetClient(),
-			TriggerClient:  cronjobTriggerClient,
-			KubelessClient: kubelessClient,
+			KubeCli:           kubeCli,
+			TriggerClient:     cronjobTriggerClient,
+			KubelessClient:    kubelessClient,
+			ClusterDomain:     clusterDomain,
+			ExecutionMode:     executionMode,
+			Scheduler:         triggerScheduler,
+			OrphanSweepPeriod: orphanSweepPeriod,
+			OrphanSweepDryRun: orphanSweepDryRun,
+			// Set to the image of the controller itself in its Deployment, which also runs the invocations
+			DefaultInvokerImage: os.Getenv("INVOKER_IMAGE"),
 		}
 
 		cronJobTriggerController := controller.NewCronJobTriggerController(cronJobTriggerCfg)
@@ -59,6 +88,19 @@ var rootCmd = &cobra.Command{
 
 		go cronJobTriggerController.Run(stopCh)
 
+		// Every replica keeps the schedules up to date but only the leader makes the invocations, records their runs,
+		// sends the alerts and deletes the CronJobs of the triggers that no longer exist
+		runLeader := func(stopCh <-chan struct{}) {
+			go cronJobTriggerController.Lead(stopCh)
+			go cronJobTriggerController.RunOrphanSweep(stopCh)
+			triggerScheduler.Run(stopCh)
+		}
+		if leaderElect {
+			go runWhenLeading(kubeCli, runLeader, stopCh)
+		} else {
+			go runLeader(stopCh)
+		}
+
 		sigterm := make(chan os.Signal, 1)
 		signal.Notify(sigterm, syscall.SIGTERM)
 		signal.Notify(sigterm, syscall.SIGINT)
@@ -66,6 +108,73 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+func init() {
+	rootCmd.Flags().StringVar(&clusterDomain, "cluster-domain", "", "DNS domain of the cluster used to build function URLs. Defaults to the cluster-domain key of the Kubeless config or cluster.local")
+	rootCmd.Flags().StringVar(&executionMode, "execution-mode", "", "Default execution mode of the triggers, either CronJob or Controller. Defaults to the cronjob-execution-mode key of the Kubeless config or CronJob")
+	rootCmd.Flags().IntVar(&schedulerWorkers, "scheduler-workers", scheduler.DefaultWorkers, "Number of invocations made at the same time by triggers in the Controller execution mode")
+	rootCmd.Flags().BoolVar(&leaderElect, "leader-elect", true, "Elect a leader among the controller replicas to make the invocations of triggers in the Controller execution mode, record the runs, send the alerts and sweep the orphaned CronJobs")
+	rootCmd.Flags().StringVar(&leaderElectNamespace, "leader-elect-namespace", "", "Namespace of the lease used for the leader election. Defaults to the namespace of the controller pod")
+	rootCmd.Flags().DurationVar(&orphanSweepPeriod, "orphan-sweep-period", controller.DefaultOrphanSweepPeriod, "Period at which the CronJobs created for triggers that no longer exist are deleted, 0 to disable it")
+	rootCmd.Flags().BoolVar(&orphanSweepDryRun, "orphan-sweep-dry-run", false, "Only report the CronJobs created for triggers that no longer exist, in logs and events, instead of deleting them")
+}
+
+// runWhenLeading calls run while this replica holds the lease of the controller, run must return when
+// the given stop channel is closed
+func runWhenLeading(client kubernetes.Interface, run func(stopCh <-chan struct{}), stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	identity, err := os.Hostname()
+	if err != nil {
+		logrus.Fatalf("Unable to get the hostname for the leader election: %v", err)
+	}
+	namespace := leaderElectNamespace
+	if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if namespace == "" {
+		namespace = "kubeless"
+	}
+
+	config := leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      leaseName,
+				Namespace: namespace,
+			},
+			Client: client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: identity,
+			},
+		},
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logrus.Infof("Became the leader, running the scheduler, the alerts and the orphan sweep")
+				run(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				logrus.Infof("Lost the leadership, stopping the scheduler and the orphan sweep")
+			},
+		},
+	}
+	for {
+		leaderelection.RunOrDie(ctx, config)
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+	}
+}
+
 func main() {
 	logrus.Infof("Running Kubeless cronjob trigger controller version: %v", version.Version)
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/kubeless/cronjob-trigger/pkg/invoker"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var invokeCmd = &cobra.Command{
+	Use:   "invoke",
+	Short: "Invoke the function of a cronjob trigger",
+	Long:  "Invoke the function of a cronjob trigger once. This command is run by the Jobs generated for cronjob triggers",
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := invoker.RequestFromEnv()
+		if err != nil {
+			logrus.Fatalf("Cannot read the invocation request: %v", err)
+		}
+		if req.ScheduledTime == nil {
+			scheduledTime, err := invoker.ScheduledTimeFromJobName(os.Getenv("JOB_NAME"))
+			if err != nil {
+				logrus.Warnf("Unable to find out the scheduled time, using the current time instead: %v", err)
+				scheduledTime = time.Now().UTC().Truncate(time.Second)
+			}
+			req.ScheduledTime = &scheduledTime
+		}
+
+		client, err := invoker.NewClient(req.TLS)
+		if err != nil {
+			logrus.Fatalf("Cannot configure the HTTP client: %v", err)
+		}
+
+		result := invoker.Invoke(context.Background(), client, req)
+
+		rawResult, err := result.TerminationMessage()
+		if err != nil {
+			logrus.Fatalf("Cannot serialize the invocation result: %v", err)
+		}
+		logrus.Debugf("Invocation result: %s", rawResult)
+		if err := ioutil.WriteFile(invoker.TerminationMessagePath, rawResult, 0644); err != nil {
+			logrus.Warnf("Unable to write the invocation result to %s: %v", invoker.TerminationMessagePath, err)
+		}
+
+		if result.Skipped != "" {
+			logrus.Infof("Skipped the call to %s: %s", req.URL, result.Skipped)
+			return
+		}
+
+		if !result.Succeeded() {
+			logrus.Fatalf("Failed to invoke %s after %d attempt(s)", req.URL, result.Attempts)
+		}
+		logrus.Infof("Invoked %s in %s, received the status %d", req.URL, result.Latency.Duration, result.StatusCode)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(invokeCmd)
+}
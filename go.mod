@@ -6,6 +6,7 @@ require (
 	github.com/golang/glog v1.0.0
 	github.com/imdario/mergo v0.3.12
 	github.com/kubeless/kubeless v1.0.8
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.4.0
 	k8s.io/api v0.24.1
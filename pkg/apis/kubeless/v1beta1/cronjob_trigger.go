@@ -17,6 +17,7 @@ limitations under the License.
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -27,16 +28,194 @@ import (
 type CronJobTrigger struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata"`
-	Spec              CronJobTriggerSpec `json:"spec"`
+	Spec              CronJobTriggerSpec   `json:"spec"`
+	Status            CronJobTriggerStatus `json:"status,omitempty"`
 }
 
 // CronJobTriggerSpec defines specification for CronJobTrigger
 type CronJobTriggerSpec struct {
-	Schedule     string      `json:"schedule"`      // Scheduled time (for Schedule type)
-	FunctionName string      `json:"function-name"` // Name of the associated function
-	Payload      interface{} `json:"payload"`       // Payload to send as the request data to the given function
+	Schedule                string                `json:"schedule,omitempty"`                // Scheduled time (for Schedule type), alternative to runAt. H spreads a field over its range based on the trigger UID. The Controller execution mode also accepts seconds and intervals such as @every 15s
+	FunctionName            string                `json:"function-name,omitempty"`           // Name of the associated function, shorthand for a function target
+	FunctionPort            string                `json:"functionPort,omitempty"`            // Name of the function service port to call, the first port is used by default
+	Target                  *Target               `json:"target,omitempty"`                  // Endpoint to call, alternative to function-name
+	FunctionSelector        *metav1.LabelSelector `json:"functionSelector,omitempty"`        // Selector of the functions to call, alternative to function-name
+	Payload                 interface{}           `json:"payload"`                           // Payload to send as the request data to the given function
+	Retry                   *RetryPolicy          `json:"retry,omitempty"`                   // Retry policy applied by the invoker within a single scheduled run
+	TLS                     *TLSConfig            `json:"tls,omitempty"`                     // TLS settings used to call the function
+	RunAt                   *metav1.Time          `json:"runAt,omitempty"`                   // Time of the single run of the trigger, alternative to schedule
+	TTLSecondsAfterFinished *int32                `json:"ttlSecondsAfterFinished,omitempty"` // Seconds after which a completed runAt trigger is deleted, it's kept by default
+	ExecutionMode           string                `json:"executionMode,omitempty"`           // Either CronJob or Controller, defaults to the execution mode of the controller
+	Jitter                  *metav1.Duration      `json:"jitter,omitempty"`                  // Maximum random delay of every run, to spread the calls of triggers sharing a schedule
+	Exclusions              []Exclusion           `json:"exclusions,omitempty"`              // Periods during which the scheduled runs are skipped
+	CatchUp                 string                `json:"catchUp,omitempty"`                 // Runs made for the schedules missed while the trigger couldn't run, either None (default), LastOnly or All
+	CatchUpLimit            int32                 `json:"catchUpLimit,omitempty"`            // Maximum number of runs made by the All catch up policy, 10 by default
+	Alerting                *AlertingPolicy       `json:"alerting,omitempty"`                // Notifications sent when the runs keep failing
+	DeadLetter              *Target               `json:"deadLetter,omitempty"`              // Endpoint receiving the payload and the failure of every failed run
+	OnSuccess               []FollowUp            `json:"onSuccess,omitempty"`               // Targets called in order after every successful call, until one of them fails
+	OnFailure               []FollowUp            `json:"onFailure,omitempty"`               // Targets called in order after every failed call, until one of them fails
+	JobTemplate             *JobTemplate          `json:"jobTemplate,omitempty"`             // Overrides of the pods of the jobs calling the target, applied on top of the default template of the controller
 }
 
+// JobTemplate overrides the pods of the jobs calling the target of a trigger
+type JobTemplate struct {
+	Labels             map[string]string            `json:"labels,omitempty"`             // Extra labels of the pods, the labels set by the controller can't be overridden
+	Resources          *corev1.ResourceRequirements `json:"resources,omitempty"`          // Resources of the invoker container, replacing the default requests and limits
+	NodeSelector       map[string]string            `json:"nodeSelector,omitempty"`       // Labels of the nodes the pods can run on
+	Tolerations        []corev1.Toleration          `json:"tolerations,omitempty"`        // Tolerations of the pods
+	ServiceAccountName string                       `json:"serviceAccountName,omitempty"` // Service account the pods run as
+	PriorityClassName  string                       `json:"priorityClassName,omitempty"`  // Priority class of the pods
+	SecurityContext    *corev1.PodSecurityContext   `json:"securityContext,omitempty"`    // Security context of the pods
+}
+
+// FollowUp is a target called after the call made by a trigger completes
+type FollowUp struct {
+	Target       Target `json:"target"`                 // Endpoint to call
+	PassResponse bool   `json:"passResponse,omitempty"` // Send the response of the previous call as the payload, instead of the payload of the trigger
+}
+
+// AlertingPolicy defines when the trigger is unhealthy and where the notifications of its health changes are sent
+type AlertingPolicy struct {
+	FailureThreshold int32  `json:"failureThreshold,omitempty"` // Number of consecutive failed runs after which the trigger is unhealthy, 3 by default
+	Target           Target `json:"target"`                     // Endpoint receiving the notifications, usually a webhook URL or a function
+}
+
+// Exclusion is a period during which the runs of a trigger are skipped. Either a time range or a calendar must be set
+type Exclusion struct {
+	Name     string       `json:"name,omitempty"`     // Description of the exclusion, reported for the skipped runs
+	Start    *metav1.Time `json:"start,omitempty"`    // Beginning of the excluded time range
+	End      *metav1.Time `json:"end,omitempty"`      // End of the excluded time range, runs scheduled at that time are not skipped
+	Calendar string       `json:"calendar,omitempty"` // Name of a TriggerCalendar listing the excluded days
+}
+
+// Target is the HTTP endpoint called by a trigger. Exactly one of its fields must be set
+type Target struct {
+	Function *FunctionTarget `json:"function,omitempty"` // Kubeless function in the namespace of the trigger
+	Service  *ServiceTarget  `json:"service,omitempty"`  // Service in the namespace of the trigger
+	URL      string          `json:"url,omitempty"`      // Any HTTP or HTTPS URL
+}
+
+// FunctionTarget references a Kubeless function
+type FunctionTarget struct {
+	Name string `json:"name"`           // Name of the function
+	Port string `json:"port,omitempty"` // Name of the function service port, the first port is used by default
+}
+
+// ServiceTarget references a Kubernetes Service
+type ServiceTarget struct {
+	Name string `json:"name"`           // Name of the service
+	Port string `json:"port,omitempty"` // Name or number of the service port, the first port is used by default
+	Path string `json:"path,omitempty"` // HTTP path to call
+}
+
+// RetryPolicy defines how failed calls are retried within a single scheduled run
+type RetryPolicy struct {
+	MaxAttempts          int32            `json:"maxAttempts,omitempty"`          // Total number of attempts, including the first one
+	InitialBackoff       *metav1.Duration `json:"initialBackoff,omitempty"`       // Delay before the first retry, doubled after every attempt
+	MaxBackoff           *metav1.Duration `json:"maxBackoff,omitempty"`           // Upper bound of the delay between attempts
+	RetryableStatusCodes []int32          `json:"retryableStatusCodes,omitempty"` // HTTP status codes that are worth a retry
+}
+
+// TLSConfig defines how to call functions served over HTTPS
+type TLSConfig struct {
+	Scheme           string                       `json:"scheme,omitempty"`           // URL scheme used to call the function, either http or https (default)
+	CABundle         *corev1.ConfigMapKeySelector `json:"caBundle,omitempty"`         // ConfigMap key holding the CA bundle that signed the function certificate
+	ClientCertSecret string                       `json:"clientCertSecret,omitempty"` // Name of a kubernetes.io/tls Secret holding the client certificate and key
+	ServerName       string                       `json:"serverName,omitempty"`       // Server name expected in the function certificate
+}
+
+// CronJobTriggerStatus is the observed state of a CronJobTrigger
+type CronJobTriggerStatus struct {
+	Conditions          []metav1.Condition `json:"conditions,omitempty"`          // Latest observations of the trigger state
+	ManualRun           *ManualRunStatus   `json:"manualRun,omitempty"`           // Last run requested through the run-now annotation
+	SkippedRuns         []SkippedRun       `json:"skippedRuns,omitempty"`         // Latest runs skipped because of an exclusion, most recent first
+	LastScheduleTime    *metav1.Time       `json:"lastScheduleTime,omitempty"`    // Latest scheduled time the trigger has been run for
+	Runs                []TriggerRun       `json:"runs,omitempty"`                // Latest runs of the trigger, most recent first
+	ConsecutiveFailures int32              `json:"consecutiveFailures,omitempty"` // Number of runs that failed since the last successful one
+	LastCompletionTime  *metav1.Time       `json:"lastCompletionTime,omitempty"`  // Completion time of the latest finished job recorded in the runs
+	CompletedRunAt      *metav1.Time       `json:"completedRunAt,omitempty"`      // Value of runAt the single run of the trigger completed for
+}
+
+// TriggerRun describes a run of a trigger
+type TriggerRun struct {
+	JobName        string           `json:"jobName,omitempty"`        // Name of the job making the run, empty for the runs of the Controller execution mode
+	ScheduledTime  *metav1.Time     `json:"scheduledTime,omitempty"`  // Time the run was scheduled for
+	StartTime      *metav1.Time     `json:"startTime,omitempty"`      // Time the run started
+	CompletionTime *metav1.Time     `json:"completionTime,omitempty"` // Time the run finished
+	Duration       *metav1.Duration `json:"duration,omitempty"`       // Time taken by the run
+	Outcome        string           `json:"outcome"`                  // Either Running, Succeeded, Failed or Skipped
+	StatusCode     int32            `json:"statusCode,omitempty"`     // HTTP status code of the last attempt
+	Attempts       int32            `json:"attempts,omitempty"`       // Number of calls made by the run
+	Message        string           `json:"message,omitempty"`        // Error of a failed run or reason of a skipped run
+	Response       string           `json:"response,omitempty"`       // Beginning of the body of the last response
+	Latency        *metav1.Duration `json:"latency,omitempty"`        // Time taken by the last attempt to get a response
+}
+
+// SkippedRun is a scheduled run that has not been made because of an exclusion
+type SkippedRun struct {
+	ScheduledTime metav1.Time `json:"scheduledTime"` // Time the run was scheduled for
+	Reason        string      `json:"reason"`        // Exclusion that prevented the run
+}
+
+// ManualRunStatus describes a run requested through the run-now annotation
+type ManualRunStatus struct {
+	Token     string      `json:"token"`          // Value of the annotation that requested the run
+	Jobs      []string    `json:"jobs,omitempty"` // Names of the jobs created for the run
+	StartTime metav1.Time `json:"startTime"`      // Time the jobs were created
+}
+
+// RunNowAnnotation requests a run of the trigger outside of its schedule. Each new value of the annotation triggers a single run
+const RunNowAnnotation = "kubeless.io/run-now"
+
+// Condition types of a CronJobTrigger
+const (
+	// ServiceAvailable tells whether the Service of the target exists and exposes the selected port
+	ServiceAvailable = "ServiceAvailable"
+	// ScheduleValid tells whether the schedule is supported by the execution mode of the trigger
+	ScheduleValid = "ScheduleValid"
+	// Completed tells whether the single run of a runAt trigger is finished
+	Completed = "Completed"
+	// Healthy tells whether the number of consecutive failed runs is below the alerting threshold of the trigger
+	Healthy = "Healthy"
+)
+
+// States reported in the alerting notifications of a CronJobTrigger
+const (
+	// AlertFailing is sent when the consecutive failed runs reach the alerting threshold
+	AlertFailing = "Failing"
+	// AlertRecovered is sent when a run succeeds after the trigger became unhealthy
+	AlertRecovered = "Recovered"
+)
+
+// Catch up policies of a CronJobTrigger
+const (
+	// CatchUpNone doesn't make the missed runs
+	CatchUpNone = "None"
+	// CatchUpLastOnly makes the most recent missed run
+	CatchUpLastOnly = "LastOnly"
+	// CatchUpAll makes every missed run, up to the catch up limit of the trigger
+	CatchUpAll = "All"
+)
+
+// Outcomes of a run of a CronJobTrigger
+const (
+	// RunRunning is the outcome of the runs that are not finished yet
+	RunRunning = "Running"
+	// RunSucceeded is the outcome of the runs that received a successful response
+	RunSucceeded = "Succeeded"
+	// RunFailed is the outcome of the runs that didn't receive a successful response
+	RunFailed = "Failed"
+	// RunSkipped is the outcome of the runs that were not made because of an exclusion
+	RunSkipped = "Skipped"
+)
+
+// Execution modes of a CronJobTrigger
+const (
+	// ExecutionModeCronJob runs every invocation in a pod created by a CronJob
+	ExecutionModeCronJob = "CronJob"
+	// ExecutionModeController makes the invocations from the controller process
+	ExecutionModeController = "Controller"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // CronJobTriggerList is list of CronJobTrigger's
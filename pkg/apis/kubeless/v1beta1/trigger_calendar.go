@@ -0,0 +1,52 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TriggerCalendar is a cluster wide list of days during which the runs of the triggers referencing it are skipped
+type TriggerCalendar struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              TriggerCalendarSpec `json:"spec"`
+}
+
+// TriggerCalendarSpec defines specification for TriggerCalendar
+type TriggerCalendarSpec struct {
+	Dates    []string `json:"dates"`              // Excluded days, in the YYYY-MM-DD format
+	TimeZone string   `json:"timeZone,omitempty"` // IANA time zone of the days, UTC by default
+}
+
+// TriggerCalendarDateFormat is the layout of the dates of a TriggerCalendar
+const TriggerCalendarDateFormat = "2006-01-02"
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TriggerCalendarList is list of TriggerCalendar's
+type TriggerCalendarList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	// Items is a list of third party objects
+	Items []*TriggerCalendar `json:"items"`
+}
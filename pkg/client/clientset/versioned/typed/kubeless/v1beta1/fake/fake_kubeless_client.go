@@ -29,6 +29,10 @@ func (c *FakeKubelessV1beta1) CronJobTriggers(namespace string) v1beta1.CronJobT
 	return &FakeCronJobTriggers{c, namespace}
 }
 
+func (c *FakeKubelessV1beta1) TriggerCalendars() v1beta1.TriggerCalendarInterface {
+	return &FakeTriggerCalendars{c}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeKubelessV1beta1) RESTClient() rest.Interface {
@@ -0,0 +1,124 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fake
+
+import (
+	v1beta1 "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeTriggerCalendars implements TriggerCalendarInterface
+type FakeTriggerCalendars struct {
+	Fake *FakeKubelessV1beta1
+}
+
+var triggercalendarsResource = schema.GroupVersionResource{Group: "kubeless.io", Version: "v1beta1", Resource: "triggercalendars"}
+
+var triggercalendarsKind = schema.GroupVersionKind{Group: "kubeless.io", Version: "v1beta1", Kind: "TriggerCalendar"}
+
+// Get takes name of the triggerCalendar, and returns the corresponding triggerCalendar object, and an error if there is any.
+func (c *FakeTriggerCalendars) Get(name string, options v1.GetOptions) (result *v1beta1.TriggerCalendar, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootGetAction(triggercalendarsResource, name), &v1beta1.TriggerCalendar{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.TriggerCalendar), err
+}
+
+// List takes label and field selectors, and returns the list of TriggerCalendars that match those selectors.
+func (c *FakeTriggerCalendars) List(opts v1.ListOptions) (result *v1beta1.TriggerCalendarList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootListAction(triggercalendarsResource, triggercalendarsKind, opts), &v1beta1.TriggerCalendarList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1beta1.TriggerCalendarList{}
+	for _, item := range obj.(*v1beta1.TriggerCalendarList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested triggerCalendars.
+func (c *FakeTriggerCalendars) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewRootWatchAction(triggercalendarsResource, opts))
+
+}
+
+// Create takes the representation of a triggerCalendar and creates it.  Returns the server's representation of the triggerCalendar, and an error, if there is any.
+func (c *FakeTriggerCalendars) Create(triggerCalendar *v1beta1.TriggerCalendar) (result *v1beta1.TriggerCalendar, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootCreateAction(triggercalendarsResource, triggerCalendar), &v1beta1.TriggerCalendar{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.TriggerCalendar), err
+}
+
+// Update takes the representation of a triggerCalendar and updates it. Returns the server's representation of the triggerCalendar, and an error, if there is any.
+func (c *FakeTriggerCalendars) Update(triggerCalendar *v1beta1.TriggerCalendar) (result *v1beta1.TriggerCalendar, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateAction(triggercalendarsResource, triggerCalendar), &v1beta1.TriggerCalendar{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.TriggerCalendar), err
+}
+
+// Delete takes name of the triggerCalendar and deletes it. Returns an error if one occurs.
+func (c *FakeTriggerCalendars) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewRootDeleteAction(triggercalendarsResource, name), &v1beta1.TriggerCalendar{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeTriggerCalendars) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewRootDeleteCollectionAction(triggercalendarsResource, listOptions)
+
+	_, err := c.Fake.Invokes(action, &v1beta1.TriggerCalendarList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched triggerCalendar.
+func (c *FakeTriggerCalendars) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.TriggerCalendar, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootPatchSubresourceAction(triggercalendarsResource, name, data, subresources...), &v1beta1.TriggerCalendar{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1beta1.TriggerCalendar), err
+}
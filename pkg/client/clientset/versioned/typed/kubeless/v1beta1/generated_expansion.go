@@ -16,3 +16,5 @@ limitations under the License.
 package v1beta1
 
 type CronJobTriggerExpansion interface{}
+
+type TriggerCalendarExpansion interface{}
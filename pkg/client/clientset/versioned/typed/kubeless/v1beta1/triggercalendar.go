@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1beta1
+
+import (
+	"context"
+
+	v1beta1 "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
+	scheme "github.com/kubeless/cronjob-trigger/pkg/client/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// TriggerCalendarsGetter has a method to return a TriggerCalendarInterface.
+// A group's client should implement this interface.
+type TriggerCalendarsGetter interface {
+	TriggerCalendars() TriggerCalendarInterface
+}
+
+// TriggerCalendarInterface has methods to work with TriggerCalendar resources.
+type TriggerCalendarInterface interface {
+	Create(*v1beta1.TriggerCalendar) (*v1beta1.TriggerCalendar, error)
+	Update(*v1beta1.TriggerCalendar) (*v1beta1.TriggerCalendar, error)
+	Delete(name string, options *v1.DeleteOptions) error
+	DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error
+	Get(name string, options v1.GetOptions) (*v1beta1.TriggerCalendar, error)
+	List(opts v1.ListOptions) (*v1beta1.TriggerCalendarList, error)
+	Watch(opts v1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.TriggerCalendar, err error)
+	TriggerCalendarExpansion
+}
+
+// triggerCalendars implements TriggerCalendarInterface
+type triggerCalendars struct {
+	client rest.Interface
+}
+
+// newTriggerCalendars returns a TriggerCalendars
+func newTriggerCalendars(c *KubelessV1beta1Client) *triggerCalendars {
+	return &triggerCalendars{
+		client: c.RESTClient(),
+	}
+}
+
+// Get takes name of the triggerCalendar, and returns the corresponding triggerCalendar object, and an error if there is any.
+func (c *triggerCalendars) Get(name string, options v1.GetOptions) (result *v1beta1.TriggerCalendar, err error) {
+	result = &v1beta1.TriggerCalendar{}
+	err = c.client.Get().
+		Resource("triggercalendars").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do(context.TODO()).
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of TriggerCalendars that match those selectors.
+func (c *triggerCalendars) List(opts v1.ListOptions) (result *v1beta1.TriggerCalendarList, err error) {
+	result = &v1beta1.TriggerCalendarList{}
+	err = c.client.Get().
+		Resource("triggercalendars").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do(context.TODO()).
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested triggerCalendars.
+func (c *triggerCalendars) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	opts.Watch = true
+	return c.client.Get().
+		Resource("triggercalendars").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Watch(context.TODO())
+}
+
+// Create takes the representation of a triggerCalendar and creates it.  Returns the server's representation of the triggerCalendar, and an error, if there is any.
+func (c *triggerCalendars) Create(triggerCalendar *v1beta1.TriggerCalendar) (result *v1beta1.TriggerCalendar, err error) {
+	result = &v1beta1.TriggerCalendar{}
+	err = c.client.Post().
+		Resource("triggercalendars").
+		Body(triggerCalendar).
+		Do(context.TODO()).
+		Into(result)
+	return
+}
+
+// Update takes the representation of a triggerCalendar and updates it. Returns the server's representation of the triggerCalendar, and an error, if there is any.
+func (c *triggerCalendars) Update(triggerCalendar *v1beta1.TriggerCalendar) (result *v1beta1.TriggerCalendar, err error) {
+	result = &v1beta1.TriggerCalendar{}
+	err = c.client.Put().
+		Resource("triggercalendars").
+		Name(triggerCalendar.Name).
+		Body(triggerCalendar).
+		Do(context.TODO()).
+		Into(result)
+	return
+}
+
+// Delete takes name of the triggerCalendar and deletes it. Returns an error if one occurs.
+func (c *triggerCalendars) Delete(name string, options *v1.DeleteOptions) error {
+	return c.client.Delete().
+		Resource("triggercalendars").
+		Name(name).
+		Body(options).
+		Do(context.TODO()).
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *triggerCalendars) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	return c.client.Delete().
+		Resource("triggercalendars").
+		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Body(options).
+		Do(context.TODO()).
+		Error()
+}
+
+// Patch applies the patch and returns the patched triggerCalendar.
+func (c *triggerCalendars) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.TriggerCalendar, err error) {
+	result = &v1beta1.TriggerCalendar{}
+	err = c.client.Patch(pt).
+		Resource("triggercalendars").
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		Do(context.TODO()).
+		Into(result)
+	return
+}
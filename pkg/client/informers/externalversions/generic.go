@@ -55,6 +55,8 @@ func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource
 	// Group=kubeless.io, Version=v1beta1
 	case v1beta1.SchemeGroupVersion.WithResource("cronjobtriggers"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Kubeless().V1beta1().CronJobTriggers().Informer()}, nil
+	case v1beta1.SchemeGroupVersion.WithResource("triggercalendars"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Kubeless().V1beta1().TriggerCalendars().Informer()}, nil
 
 	}
 
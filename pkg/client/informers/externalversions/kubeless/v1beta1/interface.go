@@ -26,6 +26,8 @@ import (
 type Interface interface {
 	// CronJobTriggers returns a CronJobTriggerInformer.
 	CronJobTriggers() CronJobTriggerInformer
+	// TriggerCalendars returns a TriggerCalendarInformer.
+	TriggerCalendars() TriggerCalendarInformer
 }
 
 type version struct {
@@ -43,3 +45,8 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 func (v *version) CronJobTriggers() CronJobTriggerInformer {
 	return &cronJobTriggerInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// TriggerCalendars returns a TriggerCalendarInformer.
+func (v *version) TriggerCalendars() TriggerCalendarInformer {
+	return &triggerCalendarInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file was automatically generated by informer-gen
+
+package v1beta1
+
+import (
+	time "time"
+
+	kubeless_v1beta1 "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
+	versioned "github.com/kubeless/cronjob-trigger/pkg/client/clientset/versioned"
+	internalinterfaces "github.com/kubeless/cronjob-trigger/pkg/client/informers/externalversions/internalinterfaces"
+	v1beta1 "github.com/kubeless/cronjob-trigger/pkg/client/listers/kubeless/v1beta1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// TriggerCalendarInformer provides access to a shared informer and lister for
+// TriggerCalendars.
+type TriggerCalendarInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1beta1.TriggerCalendarLister
+}
+
+type triggerCalendarInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+}
+
+// NewTriggerCalendarInformer constructs a new informer for TriggerCalendar type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewTriggerCalendarInformer(client versioned.Interface, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredTriggerCalendarInformer(client, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredTriggerCalendarInformer constructs a new informer for TriggerCalendar type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredTriggerCalendarInformer(client versioned.Interface, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.KubelessV1beta1().TriggerCalendars().List(options)
+			},
+			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.KubelessV1beta1().TriggerCalendars().Watch(options)
+			},
+		},
+		&kubeless_v1beta1.TriggerCalendar{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *triggerCalendarInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredTriggerCalendarInformer(client, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *triggerCalendarInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&kubeless_v1beta1.TriggerCalendar{}, f.defaultInformer)
+}
+
+func (f *triggerCalendarInformer) Lister() v1beta1.TriggerCalendarLister {
+	return v1beta1.NewTriggerCalendarLister(f.Informer().GetIndexer())
+}
@@ -25,3 +25,7 @@ type CronJobTriggerListerExpansion interface{}
 // CronJobTriggerNamespaceListerExpansion allows custom methods to be added to
 // CronJobTriggerNamespaceLister.
 type CronJobTriggerNamespaceListerExpansion interface{}
+
+// TriggerCalendarListerExpansion allows custom methods to be added to
+// TriggerCalendarLister.
+type TriggerCalendarListerExpansion interface{}
@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file was automatically generated by lister-gen
+
+package v1beta1
+
+import (
+	v1beta1 "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// TriggerCalendarLister helps list TriggerCalendars.
+type TriggerCalendarLister interface {
+	// List lists all TriggerCalendars in the indexer.
+	List(selector labels.Selector) (ret []*v1beta1.TriggerCalendar, err error)
+	// Get retrieves the TriggerCalendar from the index for a given name.
+	Get(name string) (*v1beta1.TriggerCalendar, error)
+	TriggerCalendarListerExpansion
+}
+
+// triggerCalendarLister implements the TriggerCalendarLister interface.
+type triggerCalendarLister struct {
+	indexer cache.Indexer
+}
+
+// NewTriggerCalendarLister returns a new TriggerCalendarLister.
+func NewTriggerCalendarLister(indexer cache.Indexer) TriggerCalendarLister {
+	return &triggerCalendarLister{indexer: indexer}
+}
+
+// List lists all TriggerCalendars in the indexer.
+func (s *triggerCalendarLister) List(selector labels.Selector) (ret []*v1beta1.TriggerCalendar, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1beta1.TriggerCalendar))
+	})
+	return ret, err
+}
+
+// Get retrieves the TriggerCalendar from the index for a given name.
+func (s *triggerCalendarLister) Get(name string) (*v1beta1.TriggerCalendar, error) {
+	obj, exists, err := s.indexer.GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1beta1.Resource("triggercalendar"), name)
+	}
+	return obj.(*v1beta1.TriggerCalendar), nil
+}
@@ -18,33 +18,108 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	cronjobTriggerAPi "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
 	"github.com/kubeless/cronjob-trigger/pkg/client/clientset/versioned"
+	triggerscheme "github.com/kubeless/cronjob-trigger/pkg/client/clientset/versioned/scheme"
 	cronjobInformers "github.com/kubeless/cronjob-trigger/pkg/client/informers/externalversions/kubeless/v1beta1"
+	"github.com/kubeless/cronjob-trigger/pkg/invoker"
+	"github.com/kubeless/cronjob-trigger/pkg/scheduler"
 	cronjobutils "github.com/kubeless/cronjob-trigger/pkg/utils"
 	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
 	kubelessversioned "github.com/kubeless/kubeless/pkg/client/clientset/versioned"
 	kubelessInformers "github.com/kubeless/kubeless/pkg/client/informers/externalversions/kubeless/v1beta1"
 	kubelessutils "github.com/kubeless/kubeless/pkg/utils"
 	"github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	batchInformers "k8s.io/client-go/informers/batch/v1"
+	batchv1beta1Informers "k8s.io/client-go/informers/batch/v1beta1"
+	coreInformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 )
 
+// DefaultOrphanSweepPeriod is the period at which the cron jobs of missing triggers are looked for by default
+const DefaultOrphanSweepPeriod = 10 * time.Minute
+
 const (
 	cronJobTriggerMaxRetries = 11
-	cronJobObjKind           = "Trigger"
+	cronJobObjKind           = "CronJobTrigger"
 	cronJobAPIVersion        = "kubeless.io/v1beta1"
 	cronJobTriggerFinalizer  = "kubeless.io/cronjobtrigger"
+	defaultClusterDomain     = "cluster.local"
+	// Kind wrongly set in the owner references of the cron jobs created by previous versions of the controller,
+	// they are migrated to cronJobObjKind when their trigger is synced
+	legacyCronJobObjKind = "Trigger"
+	// Age under which a cron job isn't considered orphaned, since its trigger may not be cached yet
+	orphanGracePeriod = time.Minute
+	// Period at which the jobs of runAt triggers are checked until they finish
+	singleRunPollPeriod = 10 * time.Second
+	// Number of skipped runs kept in the status of a trigger
+	maxSkippedRuns = 10
+	// Number of runs kept in the status of a trigger
+	maxRuns = 10
+	// Index of the jobs by the key of their trigger
+	triggerIndex = "trigger"
+	// Index of the triggers by the keys of the services they call
+	serviceIndex = "service"
+	// Index of the triggers selecting their functions by the namespace of the functions
+	selectorIndex = "selector"
+	// Number of missed runs made by the All catch up policy by default
+	defaultCatchUpLimit = 10
+	// Delay after which a run is considered missed, on top of the deadline of its requests
+	catchUpGracePeriod = time.Minute
+	// Component reported as the source of the events
+	eventComponent = "cronjob-trigger-controller"
+	// Number of consecutive failed runs after which a trigger is unhealthy by default
+	defaultFailureThreshold = 3
+	// Timeout of the calls sending the alerting notifications and the dead letters
+	postTimeoutSeconds = 10
+	// Number of calls made to send an alerting notification or a dead letter before giving up
+	deliveryMaxAttempts = 5
+	// Delays between the calls sending an alerting notification or a dead letter, doubled after each failed one
+	deliveryBaseDelay = time.Second
+	deliveryMaxDelay  = time.Minute
+)
+
+// Reasons of the events emitted for a trigger
+const (
+	eventCronJobCreated     = "CronJobCreated"
+	eventCronJobUpdated     = "CronJobUpdated"
+	eventCronJobDeleted     = "CronJobDeleted"
+	eventCronJobDrift       = "CronJobDrift"
+	eventCronJobAdopted     = "CronJobAdopted"
+	eventOrphanedCronJob    = "OrphanedCronJob"
+	eventFunctionNotFound   = "FunctionNotFound"
+	eventConflictingCronJob = "ConflictingCronJob"
+	eventInvocationFailed   = "InvocationFailed"
+	eventRunSkipped         = "RunSkipped"
+	eventAlertSent          = "AlertSent"
+	eventAlertFailed        = "AlertFailed"
+	eventDeadLetterSent     = "DeadLetterSent"
+	eventDeadLetterFailed   = "DeadLetterFailed"
 )
 
 // CronJobTriggerController object
@@ -57,7 +132,32 @@ type CronJobTriggerController struct {
 	queue            workqueue.RateLimitingInterface
 	cronJobInformer  cache.SharedIndexInformer
 	functionInformer cache.SharedIndexInformer
+	calendarInformer cache.SharedIndexInformer
+	jobInformer      cache.SharedIndexInformer
+	ownedCronJobs    cache.SharedIndexInformer
+	serviceInformer  cache.SharedIndexInformer
 	imagePullSecrets []corev1.LocalObjectReference
+	invokerImage     string
+	clusterDomain    string
+	executionMode    string
+	jobTemplate      *cronjobTriggerAPi.JobTemplate
+	scheduler        *scheduler.Scheduler
+	recorder         record.EventRecorder
+	// Alerting notifications and dead letters waiting to be sent by the leader, retried with a backoff.
+	// Nil while this replica is not leading, guarded by leadingLock
+	deliveries          workqueue.RateLimitingInterface
+	deliveryRateLimiter workqueue.RateLimiter
+	leadingLock         sync.Mutex
+	// Period of the sweep of the orphaned cron jobs, disabled when zero
+	orphanSweepPeriod time.Duration
+	// Orphaned cron jobs are only reported when true
+	orphanSweepDryRun bool
+	// Keys of the triggers whose CronJobs have been modified or deleted since their last sync
+	drifted     map[string]bool
+	driftedLock sync.Mutex
+	// Resource versions of the CronJobs last written by the controller, empty for the ones it deleted,
+	// so that its own changes are not reported as drifts. Guarded by driftedLock
+	written map[string]string
 }
 
 // CronJobTriggerConfig contains config for CronJobTriggerController
@@ -65,6 +165,15 @@ type CronJobTriggerConfig struct {
 	KubeCli        kubernetes.Interface
 	TriggerClient  versioned.Interface
 	KubelessClient kubelessversioned.Interface
+	ClusterDomain  string
+	ExecutionMode  string
+	Scheduler      *scheduler.Scheduler
+	// Image of the invocation jobs used when the Kubeless config doesn't set one, normally the image of the controller
+	DefaultInvokerImage string
+	// Period at which the cron jobs of missing triggers are deleted, zero to disable it
+	OrphanSweepPeriod time.Duration
+	// Only report the cron jobs of missing triggers instead of deleting them
+	OrphanSweepDryRun bool
 }
 
 // NewCronJobTriggerController initializes a controller object
@@ -76,9 +185,21 @@ func NewCronJobTriggerController(cfg CronJobTriggerConfig) *CronJobTriggerContro
 		logrus.Fatalf("Unable to read the configmap: %s", err)
 	}
 
-	cronJobInformer := cronjobInformers.NewCronJobTriggerInformer(cfg.TriggerClient, config.Data["functions-namespace"], 0, cache.Indexers{})
+	cronJobInformer := cronjobInformers.NewCronJobTriggerInformer(cfg.TriggerClient, config.Data["functions-namespace"], 0, cache.Indexers{serviceIndex: serviceTriggerIndexFunc, selectorIndex: selectorTriggerIndexFunc})
+
+	functionInformer := kubelessInformers.NewFunctionInformer(cfg.KubelessClient, config.Data["functions-namespace"], 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+
+	calendarInformer := cronjobInformers.NewTriggerCalendarInformer(cfg.TriggerClient, 0, cache.Indexers{})
+
+	jobInformer := batchInformers.NewFilteredJobInformer(cfg.KubeCli, config.Data["functions-namespace"], 0, cache.Indexers{triggerIndex: jobTriggerIndexFunc}, func(options *metav1.ListOptions) {
+		options.LabelSelector = cronjobutils.DefaultLabelSelector
+	})
+
+	serviceInformer := coreInformers.NewServiceInformer(cfg.KubeCli, config.Data["functions-namespace"], 0, cache.Indexers{})
 
-	functionInformer := kubelessInformers.NewFunctionInformer(cfg.KubelessClient, config.Data["functions-namespace"], 0, cache.Indexers{})
+	ownedCronJobs := batchv1beta1Informers.NewFilteredCronJobInformer(cfg.KubeCli, config.Data["functions-namespace"], 0, cache.Indexers{}, func(options *metav1.ListOptions) {
+		options.LabelSelector = cronjobutils.DefaultLabelSelector
+	})
 
 	cronJobInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
@@ -104,16 +225,69 @@ func NewCronJobTriggerController(cfg CronJobTriggerConfig) *CronJobTriggerContro
 			}
 		},
 	})
+	invokerImage := config.Data["cronjob-invoker-image"]
+	if invokerImage == "" {
+		invokerImage = cfg.DefaultInvokerImage
+	}
+	if invokerImage == "" {
+		// Previous versions of the controller ran the provision image in the cron jobs
+		invokerImage = config.Data["provision-image"]
+		logrus.Warnf("Neither the cronjob-invoker-image key of the configmap nor the invoker image of the controller are set, using the provision-image %q which must contain the invoker", invokerImage)
+	}
+	if invokerImage == "" {
+		logrus.Fatalf("No image configured for the invocation jobs, set the cronjob-invoker-image key of the configmap")
+	}
+
+	clusterDomain := cfg.ClusterDomain
+	if clusterDomain == "" {
+		clusterDomain = config.Data["cluster-domain"]
+	}
+	if clusterDomain == "" {
+		clusterDomain = defaultClusterDomain
+	}
+
+	executionMode := cfg.ExecutionMode
+	if executionMode == "" {
+		executionMode = config.Data["cronjob-execution-mode"]
+	}
+
+	// Default overrides of the pods of the jobs, applied before the ones of every trigger
+	var jobTemplate *cronjobTriggerAPi.JobTemplate
+	if rawTemplate := config.Data["cronjob-job-template"]; rawTemplate != "" {
+		jobTemplate = &cronjobTriggerAPi.JobTemplate{}
+		err = yaml.NewYAMLOrJSONDecoder(strings.NewReader(rawTemplate), len(rawTemplate)).Decode(jobTemplate)
+		if err != nil {
+			logrus.Fatalf("Unable to parse the cronjob-job-template key of the configmap: %s", err)
+		}
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cfg.KubeCli.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(triggerscheme.Scheme, corev1.EventSource{Component: eventComponent})
+
 	controller := CronJobTriggerController{
-		logger:           logrus.WithField("controller", "cronjob-trigger-controller"),
-		clientset:        cfg.KubeCli,
-		kubelessclient:   cfg.KubelessClient,
-		cronjobclient:    cfg.TriggerClient,
-		config:           config,
-		cronJobInformer:  cronJobInformer,
-		functionInformer: functionInformer,
-		queue:            queue,
-		imagePullSecrets: cronjobutils.GetSecretsAsLocalObjectReference(config.Data["provision-image-secret"], config.Data["builder-image-secret"]),
+		logger:              logrus.WithField("controller", "cronjob-trigger-controller"),
+		clientset:           cfg.KubeCli,
+		kubelessclient:      cfg.KubelessClient,
+		cronjobclient:       cfg.TriggerClient,
+		config:              config,
+		cronJobInformer:     cronJobInformer,
+		functionInformer:    functionInformer,
+		calendarInformer:    calendarInformer,
+		jobInformer:         jobInformer,
+		ownedCronJobs:       ownedCronJobs,
+		serviceInformer:     serviceInformer,
+		queue:               queue,
+		deliveryRateLimiter: workqueue.NewItemExponentialFailureRateLimiter(deliveryBaseDelay, deliveryMaxDelay),
+		imagePullSecrets:    cronjobutils.GetSecretsAsLocalObjectReference(config.Data["provision-image-secret"], config.Data["builder-image-secret"]),
+		invokerImage:        invokerImage,
+		clusterDomain:       clusterDomain,
+		executionMode:       executionMode,
+		jobTemplate:         jobTemplate,
+		scheduler:           cfg.Scheduler,
+		recorder:            recorder,
+		orphanSweepPeriod:   cfg.OrphanSweepPeriod,
+		orphanSweepDryRun:   cfg.OrphanSweepDryRun,
 	}
 
 	functionInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -124,10 +298,65 @@ func NewCronJobTriggerController(cfg CronJobTriggerConfig) *CronJobTriggerContro
 			controller.functionAddedDeletedUpdated(obj, true)
 		},
 		UpdateFunc: func(old, new interface{}) {
+			oldObj := old.(*kubelessApi.Function)
+			newObj := new.(*kubelessApi.Function)
+			// Triggers that selected the function before its labels changed may have to drop it
+			if !reflect.DeepEqual(oldObj.ObjectMeta.Labels, newObj.ObjectMeta.Labels) {
+				controller.enqueueSelectingTriggers(oldObj)
+			}
 			controller.functionAddedDeletedUpdated(new, false)
 		},
 	})
 
+	calendarInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			controller.enqueueCalendarTriggers(obj)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueueCalendarTriggers(new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			controller.enqueueCalendarTriggers(obj)
+		},
+	})
+
+	jobInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			controller.enqueueJobTrigger(obj)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueueJobTrigger(new)
+		},
+	})
+
+	ownedCronJobs.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			controller.cronJobUpdated(old, new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			controller.cronJobDeleted(obj)
+		},
+	})
+
+	serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			controller.enqueueServiceTriggers(obj)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			// The endpoints of the triggers only depend on the ports of the service
+			if !reflect.DeepEqual(old.(*corev1.Service).Spec.Ports, new.(*corev1.Service).Spec.Ports) {
+				controller.enqueueServiceTriggers(new)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			controller.enqueueServiceTriggers(obj)
+		},
+	})
+
+	if cfg.Scheduler != nil {
+		cfg.Scheduler.OnResult(controller.recordResult)
+	}
+
 	return &controller
 }
 
@@ -140,6 +369,10 @@ func (c *CronJobTriggerController) Run(stopCh <-chan struct{}) {
 
 	go c.cronJobInformer.Run(stopCh)
 	go c.functionInformer.Run(stopCh)
+	go c.calendarInformer.Run(stopCh)
+	go c.jobInformer.Run(stopCh)
+	go c.ownedCronJobs.Run(stopCh)
+	go c.serviceInformer.Run(stopCh)
 
 	if !c.WaitForCacheSync(stopCh) {
 		return
@@ -150,9 +383,52 @@ func (c *CronJobTriggerController) Run(stopCh <-chan struct{}) {
 	wait.Until(c.runWorker, time.Second, stopCh)
 }
 
+// RunOrphanSweep periodically deletes the cron jobs of the triggers that no longer exist until the stop channel
+// is closed. Only one replica of the controller should run it at a time, the one elected as leader
+func (c *CronJobTriggerController) RunOrphanSweep(stopCh <-chan struct{}) {
+	if c.orphanSweepPeriod <= 0 || !c.WaitForCacheSync(stopCh) {
+		return
+	}
+	wait.Until(c.sweepCronJobs, c.orphanSweepPeriod, stopCh)
+}
+
+// Lead marks this replica as the leader and sends the alerting notifications and dead letters until the stop channel
+// is closed. Only one replica of the controller should run it at a time, the one elected as leader
+func (c *CronJobTriggerController) Lead(stopCh <-chan struct{}) {
+	deliveries := workqueue.NewRateLimitingQueue(c.deliveryRateLimiter)
+	c.setDeliveries(deliveries)
+	defer func() {
+		// The payloads not sent yet are given up, the next leader doesn't know about them
+		c.setDeliveries(nil)
+		deliveries.ShutDown()
+	}()
+	// The runs of the jobs that finished while no replica was leading are recorded by the next sync of their trigger
+	for _, key := range c.cronJobInformer.GetStore().ListKeys() {
+		c.queue.Add(key)
+	}
+	go wait.Until(func() { c.runDeliveryWorker(deliveries) }, time.Second, stopCh)
+	<-stopCh
+}
+
+func (c *CronJobTriggerController) setDeliveries(deliveries workqueue.RateLimitingInterface) {
+	c.leadingLock.Lock()
+	defer c.leadingLock.Unlock()
+	c.deliveries = deliveries
+}
+
+func (c *CronJobTriggerController) getDeliveries() workqueue.RateLimitingInterface {
+	c.leadingLock.Lock()
+	defer c.leadingLock.Unlock()
+	return c.deliveries
+}
+
+func (c *CronJobTriggerController) isLeading() bool {
+	return c.getDeliveries() != nil
+}
+
 // WaitForCacheSync is required for caches to be synced
 func (c *CronJobTriggerController) WaitForCacheSync(stopCh <-chan struct{}) bool {
-	if !cache.WaitForCacheSync(stopCh, c.cronJobInformer.HasSynced, c.functionInformer.HasSynced) {
+	if !cache.WaitForCacheSync(stopCh, c.cronJobInformer.HasSynced, c.functionInformer.HasSynced, c.calendarInformer.HasSynced, c.jobInformer.HasSynced, c.ownedCronJobs.HasSynced, c.serviceInformer.HasSynced) {
 		utilruntime.HandleError(fmt.Errorf("Timed out waiting for caches required for Cronjob triggers controller to sync;"))
 		return false
 	}
@@ -193,11 +469,6 @@ func (c *CronJobTriggerController) processNextItem() bool {
 func (c *CronJobTriggerController) syncCronJobTrigger(key string) error {
 	c.logger.Infof("Processing update to CronJob Trigger: %s", key)
 
-	ns, name, err := cache.SplitMetaNamespaceKey(key)
-	if err != nil {
-		return err
-	}
-
 	obj, exists, err := c.cronJobInformer.GetIndexer().GetByKey(key)
 	if err != nil {
 		return fmt.Errorf("Error fetching object with key %s from store: %v", key, err)
@@ -206,6 +477,9 @@ func (c *CronJobTriggerController) syncCronJobTrigger(key string) error {
 	// this is an update when CronJob trigger API object is actually deleted, we dont need to process anything here
 	if !exists {
 		c.logger.Infof("Cronjob Trigger %s not found, ignoring", key)
+		if c.scheduler != nil {
+			c.scheduler.Remove(key)
+		}
 		return nil
 	}
 
@@ -219,10 +493,14 @@ func (c *CronJobTriggerController) syncCronJobTrigger(key string) error {
 			return nil
 		}
 
-		// CronJob Trigger object should be deleted, so remove associated cronjob and remove the finalizer
-		err = c.clientset.BatchV1beta1().CronJobs(ns).Delete(context.TODO(), name, metav1.DeleteOptions{})
-		if err != nil && !k8sErrors.IsNotFound(err) {
-			c.logger.Errorf("Failed to remove CronJob created for CronJobTrigger Obj: %s due to: %v: ", key, err)
+		if c.scheduler != nil {
+			c.scheduler.Remove(key)
+		}
+
+		// CronJob Trigger object should be deleted, so remove the cronjobs it owns and remove the finalizer
+		err = c.deleteStaleCronJobs(cronJobtriggerObj, nil)
+		if err != nil {
+			c.logger.Errorf("Failed to remove CronJobs created for CronJobTrigger Obj: %s due to: %v: ", key, err)
 			return err
 		}
 
@@ -251,93 +529,1690 @@ func (c *CronJobTriggerController) syncCronJobTrigger(key string) error {
 		return err
 	}
 
-	functionObj, err := c.kubelessclient.KubelessV1beta1().Functions(ns).Get(cronJobtriggerObj.Spec.FunctionName, metav1.GetOptions{})
+	target, err := cronjobutils.GetTarget(&cronJobtriggerObj.Spec)
+	if err != nil {
+		c.logger.Errorf("Invalid target in CronJob trigger %s: %v", key, err)
+		return err
+	}
+	if cronJobtriggerObj.Spec.DeadLetter != nil {
+		if err := cronjobutils.ValidateTarget(cronJobtriggerObj.Spec.DeadLetter); err != nil {
+			c.logger.Errorf("Invalid dead-letter target in CronJob trigger %s: %v", key, err)
+			return err
+		}
+	}
+	executionMode, err := c.getExecutionMode(cronJobtriggerObj)
+	if err != nil {
+		c.logger.Errorf("Invalid execution mode in CronJob trigger %s: %v", key, err)
+		return err
+	}
+	valid, err := c.validateSchedule(cronJobtriggerObj, executionMode)
+	if err != nil {
+		return err
+	}
+	cronJobtriggerObj, err = c.resolveExclusions(cronJobtriggerObj)
 	if err != nil {
-		c.logger.Errorf("Unable to find the function %s in the namespace %s. Received %s: ", cronJobtriggerObj.Spec.FunctionName, ns, err)
+		c.logger.Errorf("Unable to resolve the exclusions of the CronJob trigger %s: %v", key, err)
 		return err
 	}
-	err = cronjobutils.EnsureCronJob(c.clientset, functionObj, cronJobtriggerObj, c.config.Data["provision-image"], or, c.imagePullSecrets)
+	cronJobtriggerObj, err = c.resolveFollowUps(cronJobtriggerObj)
 	if err != nil {
+		c.logger.Errorf("Unable to resolve the follow-ups of the CronJob trigger %s: %v", key, err)
 		return err
 	}
+	cronJobtriggerObj = c.resolveJobTemplate(cronJobtriggerObj)
 
-	c.logger.Infof("Processed update to CronJobrigger: %s", key)
-	return nil
-}
+	var calls []endpointCall
+	var selectedFunctions []*kubelessApi.Function
+	if target == nil {
+		calls, selectedFunctions, err = c.resolveSelectedFunctions(cronJobtriggerObj)
+	} else {
+		var call endpointCall
+		call, err = c.resolveTarget(cronJobtriggerObj, target)
+		calls = []endpointCall{call}
+	}
+	if err != nil {
+		return err
+	}
 
-func (c *CronJobTriggerController) functionAddedDeletedUpdated(obj interface{}, deleted bool) error {
-	functionObj, ok := obj.(*kubelessApi.Function)
-	if !ok {
-		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
-		if !ok {
-			err := fmt.Errorf("Couldn't get object from tombstone %#v", obj)
-			c.logger.Errorf(err.Error())
+	// Runs requested on demand don't depend on the schedule
+	err = c.runNow(cronJobtriggerObj, calls, or)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		// Retrying won't help until the trigger is updated
+		return nil
+	}
+
+	if cronJobtriggerObj.Spec.RunAt != nil {
+		err = c.syncSingleRun(key, cronJobtriggerObj, calls, or)
+		if err != nil {
 			return err
 		}
-		functionObj, ok = tombstone.Obj.(*kubelessApi.Function)
-		if !ok {
-			err := fmt.Errorf("Tombstone contained object that is not a Pod %#v", obj)
-			c.logger.Errorf(err.Error())
+	} else if executionMode == cronjobTriggerAPi.ExecutionModeController {
+		err = c.scheduleInvocations(key, cronJobtriggerObj, calls)
+		if err != nil {
+			return err
+		}
+		// The missed runs are made by the scheduler of the leader, like the scheduled ones
+		if c.isLeading() {
+			err = c.catchUp(cronJobtriggerObj, calls, or, cronJobtriggerObj.Status.LastScheduleTime, executionMode)
+			if err != nil {
+				return err
+			}
+		}
+		// Cron jobs created before switching to the controller execution mode are not needed anymore
+		err = c.deleteStaleCronJobs(cronJobtriggerObj, nil)
+		if err != nil {
+			return err
+		}
+	} else {
+		if c.scheduler != nil {
+			c.scheduler.Remove(key)
+		}
+		drifted := c.hasDrifted(key)
+		for _, call := range calls {
+			err = c.ensureCronJob(cronJobtriggerObj, call, or, drifted)
+			if err != nil {
+				return err
+			}
+		}
+		err = c.catchUp(cronJobtriggerObj, calls, or, cronJobtriggerObj.Status.LastScheduleTime, executionMode)
+		if err != nil {
+			return err
+		}
+		if target != nil {
+			// Cron jobs created under a previous name of the target are not needed anymore
+			selectedFunctions = []*kubelessApi.Function{calls[0].functionObj}
+		}
+		err = c.deleteStaleCronJobs(cronJobtriggerObj, selectedFunctions)
+		if err != nil {
 			return err
 		}
 	}
 
-	c.logger.Infof("Processing update to function object %s Namespace: %s", functionObj.Name, functionObj.Namespace)
-	if deleted {
-		c.logger.Infof("Function %s deleted. Removing associated cronjob trigger", functionObj.Name)
-		cjtList, err := c.cronjobclient.KubelessV1beta1().CronJobTriggers(functionObj.Namespace).List(metav1.ListOptions{})
+	// Only the leader records the runs of the jobs, so that a single replica alerts on their failures
+	if c.isLeading() {
+		err = c.recordJobRuns(cronJobtriggerObj)
 		if err != nil {
+			c.logger.Errorf("Unable to record the runs of the CronJob trigger %s: %v", key, err)
 			return err
 		}
-		for _, cjt := range cjtList.Items {
-			if cjt.Spec.FunctionName == functionObj.Name {
-				err = c.cronjobclient.KubelessV1beta1().CronJobTriggers(functionObj.Namespace).Delete(cjt.Name, &metav1.DeleteOptions{})
-				if err != nil && !k8sErrors.IsNotFound(err) {
-					c.logger.Errorf("Failed to delete cronjobtrigger created for the function %s in namespace %s, Error: %s", functionObj.ObjectMeta.Name, functionObj.ObjectMeta.Namespace, err)
-					return err
-				}
+	}
+	err = c.syncHealth(cronJobtriggerObj)
+	if err != nil {
+		c.logger.Errorf("Unable to update the health of the CronJob trigger %s: %v", key, err)
+		return err
+	}
+
+	c.clearDrift(key)
+	c.logger.Infof("Processed update to CronJobrigger: %s", key)
+	return nil
+}
+
+// endpointCall is an endpoint called on every run of a trigger, along with the function serving it if any
+type endpointCall struct {
+	functionObj *kubelessApi.Function
+	endpoint    string
+}
+
+// resolveTarget returns the call to make for the target of the trigger
+func (c *CronJobTriggerController) resolveTarget(triggerObj *cronjobTriggerAPi.CronJobTrigger, target *cronjobTriggerAPi.Target) (endpointCall, error) {
+	ns := triggerObj.ObjectMeta.Namespace
+	switch {
+	case target.Function != nil:
+		functionObj, err := c.kubelessclient.KubelessV1beta1().Functions(ns).Get(target.Function.Name, metav1.GetOptions{})
+		if err != nil {
+			c.logger.Errorf("Unable to find the function %s in the namespace %s. Received %s: ", target.Function.Name, ns, err)
+			if k8sErrors.IsNotFound(err) {
+				c.eventf(triggerObj, corev1.EventTypeWarning, eventFunctionNotFound, "Function %s not found", target.Function.Name)
+			}
+			return endpointCall{}, err
+		}
+		endpoint, err := c.resolveServiceEndpoint(triggerObj, functionObj.ObjectMeta.Name, target.Function.Port, "")
+		return endpointCall{functionObj: functionObj, endpoint: endpoint}, err
+	case target.Service != nil:
+		endpoint, err := c.resolveServiceEndpoint(triggerObj, target.Service.Name, target.Service.Port, target.Service.Path)
+		return endpointCall{endpoint: endpoint}, err
+	default:
+		// The availability of external endpoints is not tracked
+		err := c.updateStatus(triggerObj, func(status *cronjobTriggerAPi.CronJobTriggerStatus) {
+			meta.RemoveStatusCondition(&status.Conditions, cronjobTriggerAPi.ServiceAvailable)
+		})
+		return endpointCall{endpoint: target.URL}, err
+	}
+}
+
+// resolveSelectedFunctions returns the calls to the functions matching the selector of the trigger, along with
+// every selected function, and reports the functions that can't be called in the ServiceAvailable condition
+func (c *CronJobTriggerController) resolveSelectedFunctions(triggerObj *cronjobTriggerAPi.CronJobTrigger) ([]endpointCall, []*kubelessApi.Function, error) {
+	selector, err := metav1.LabelSelectorAsSelector(triggerObj.Spec.FunctionSelector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid function selector: %v", err)
+	}
+	var functions []*kubelessApi.Function
+	err = cache.ListAllByNamespace(c.functionInformer.GetIndexer(), triggerObj.ObjectMeta.Namespace, selector, func(obj interface{}) {
+		functions = append(functions, obj.(*kubelessApi.Function))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	condition := metav1.Condition{
+		Type:    cronjobTriggerAPi.ServiceAvailable,
+		Status:  metav1.ConditionTrue,
+		Reason:  "ServiceFound",
+		Message: fmt.Sprintf("Calling %d function(s)", len(functions)),
+	}
+	if len(functions) == 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NoFunctionSelected"
+		condition.Message = "No function matches the selector"
+	}
+	var calls []endpointCall
+	var unavailable []string
+	for _, functionObj := range functions {
+		endpoint, reason, err := c.getServiceEndpoint(triggerObj, functionObj.ObjectMeta.Name, triggerObj.Spec.FunctionPort, "")
+		if err != nil {
+			if reason == "" {
+				return nil, nil, err
 			}
+			// The cron job of the function is kept until its service is back
+			c.logger.Errorf("Unable to find the endpoint of the function %s in the namespace %s: %v", functionObj.ObjectMeta.Name, functionObj.ObjectMeta.Namespace, err)
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = reason
+			unavailable = append(unavailable, functionObj.ObjectMeta.Name)
+			continue
+		}
+		calls = append(calls, endpointCall{functionObj: functionObj, endpoint: endpoint})
+	}
+	if len(unavailable) != 0 {
+		condition.Message = fmt.Sprintf("Unable to call the function(s) %s", strings.Join(unavailable, ", "))
+	}
+	if err := c.setCondition(triggerObj, condition); err != nil {
+		return nil, nil, err
+	}
+	return calls, functions, nil
+}
+
+// ensureCronJob creates or updates the cron job making the given call and reports it in the events of the trigger.
+// drifted tells whether the cron jobs of the trigger have been modified or deleted since its last sync, in which case
+// a change is reported as the correction of a drift
+func (c *CronJobTriggerController) ensureCronJob(triggerObj *cronjobTriggerAPi.CronJobTrigger, call endpointCall, or []metav1.OwnerReference, drifted bool) error {
+	cronJob, result, err := cronjobutils.EnsureCronJob(c.clientset, call.functionObj, triggerObj, call.endpoint, c.invokerImage, or, c.imagePullSecrets)
+	if err != nil {
+		if cronjobutils.IsConflict(err) {
+			c.eventf(triggerObj, corev1.EventTypeWarning, eventConflictingCronJob, "%v", err)
 		}
+		return err
+	}
+	if result != cronjobutils.OperationResultNone {
+		c.recordWrite(cronJob.ObjectMeta.Namespace, cronJob.ObjectMeta.Name, cronJob.ObjectMeta.ResourceVersion)
+	}
+	switch {
+	case drifted && result == cronjobutils.OperationResultCreated:
+		c.eventf(triggerObj, corev1.EventTypeWarning, eventCronJobDrift, "Recreated the CronJob calling %s, deleted outside of the trigger", call.endpoint)
+	case drifted && result == cronjobutils.OperationResultUpdated:
+		c.eventf(triggerObj, corev1.EventTypeWarning, eventCronJobDrift, "Restored the CronJob calling %s, modified outside of the trigger", call.endpoint)
+	case result == cronjobutils.OperationResultCreated:
+		c.eventf(triggerObj, corev1.EventTypeNormal, eventCronJobCreated, "Created CronJob calling %s", call.endpoint)
+	case result == cronjobutils.OperationResultUpdated:
+		c.eventf(triggerObj, corev1.EventTypeNormal, eventCronJobUpdated, "Updated CronJob calling %s", call.endpoint)
 	}
 	return nil
 }
 
-func (c *CronJobTriggerController) cronJobTriggerObjHasFinalizer(triggerObj *cronjobTriggerAPi.CronJobTrigger) bool {
-	currentFinalizers := triggerObj.ObjectMeta.Finalizers
-	for _, f := range currentFinalizers {
-		if f == cronJobTriggerFinalizer {
-			return true
+// cronJobChanged returns true if the cron job has been changed in a way the controller has to revert.
+// Changes of its status, like its last schedule time, are ignored
+func cronJobChanged(oldObj, newObj *batchv1beta1.CronJob) bool {
+	return !equality.Semantic.DeepEqual(oldObj.Spec, newObj.Spec) ||
+		!equality.Semantic.DeepEqual(oldObj.ObjectMeta.Labels, newObj.ObjectMeta.Labels) ||
+		!equality.Semantic.DeepEqual(oldObj.ObjectMeta.OwnerReferences, newObj.ObjectMeta.OwnerReferences)
+}
+
+// cronJobUpdated enqueues the trigger owning the updated cron job, unless the controller made the change itself
+func (c *CronJobTriggerController) cronJobUpdated(oldObj, newObj interface{}) {
+	oldCronJob, ok := oldObj.(*batchv1beta1.CronJob)
+	if !ok {
+		return
+	}
+	newCronJob, ok := newObj.(*batchv1beta1.CronJob)
+	if !ok {
+		return
+	}
+	if !cronJobChanged(oldCronJob, newCronJob) || c.isOwnWrite(newCronJob, false) {
+		return
+	}
+	c.enqueueCronJobOwner(newCronJob)
+}
+
+// cronJobDeleted enqueues the trigger owning the deleted cron job, unless the controller deleted it itself
+func (c *CronJobTriggerController) cronJobDeleted(obj interface{}) {
+	cronJob, ok := obj.(*batchv1beta1.CronJob)
+	if tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown); isTombstone {
+		cronJob, ok = tombstone.Obj.(*batchv1beta1.CronJob)
+	}
+	if !ok || c.isOwnWrite(cronJob, true) {
+		return
+	}
+	c.enqueueCronJobOwner(cronJob)
+}
+
+// enqueueCronJobOwner enqueues the trigger owning the given cron job, and records that its cron jobs drifted
+func (c *CronJobTriggerController) enqueueCronJobOwner(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	cronJob, ok := obj.(*batchv1beta1.CronJob)
+	if !ok {
+		return
+	}
+	for _, owner := range cronJob.ObjectMeta.OwnerReferences {
+		if !isTriggerReference(owner) {
+			continue
 		}
+		key := cronJob.ObjectMeta.Namespace + "/" + owner.Name
+		c.driftedLock.Lock()
+		if c.drifted == nil {
+			c.drifted = map[string]bool{}
+		}
+		c.drifted[key] = true
+		c.driftedLock.Unlock()
+		c.queue.Add(key)
 	}
-	return false
 }
 
-func (c *CronJobTriggerController) cronJobTriggerObjAddFinalizer(triggercObj *cronjobTriggerAPi.CronJobTrigger) error {
-	triggercObjClone := triggercObj.DeepCopy()
-	triggercObjClone.ObjectMeta.Finalizers = append(triggercObjClone.ObjectMeta.Finalizers, cronJobTriggerFinalizer)
-	return cronjobutils.UpdateCronJobCustomResource(c.cronjobclient, triggercObjClone)
+// isTriggerReference returns true if the owner reference points to a trigger
+func isTriggerReference(owner metav1.OwnerReference) bool {
+	return (owner.Kind == cronJobObjKind || owner.Kind == legacyCronJobObjKind) && owner.APIVersion == cronJobAPIVersion
 }
 
-func (c *CronJobTriggerController) cronJobTriggerObjRemoveFinalizer(triggercObj *cronjobTriggerAPi.CronJobTrigger) error {
-	triggerObjClone := triggercObj.DeepCopy()
-	newSlice := make([]string, 0)
-	for _, item := range triggerObjClone.ObjectMeta.Finalizers {
-		if item == cronJobTriggerFinalizer {
+// getCronJobTriggerName returns the name of the trigger the cron job has been created for,
+// empty if it hasn't been created by a trigger
+func getCronJobTriggerName(cronJob *batchv1beta1.CronJob) string {
+	for _, owner := range cronJob.ObjectMeta.OwnerReferences {
+		if isTriggerReference(owner) {
+			return owner.Name
+		}
+	}
+	return cronjobutils.GetJobTriggerName(cronJob.Spec.JobTemplate.ObjectMeta)
+}
+
+// sweepCronJobs deletes the cron jobs created by the controller whose trigger is gone, which happens
+// when the controller stops before removing them, and adopts the ones that lost their owner reference
+func (c *CronJobTriggerController) sweepCronJobs() {
+	for _, obj := range c.ownedCronJobs.GetStore().List() {
+		cronJob := obj.(*batchv1beta1.CronJob)
+		name := getCronJobTriggerName(cronJob)
+		if name == "" || time.Since(cronJob.ObjectMeta.CreationTimestamp.Time) < orphanGracePeriod {
 			continue
 		}
-		newSlice = append(newSlice, item)
+		key := cronJob.ObjectMeta.Namespace + "/" + name
+		triggerObj, exists, err := c.cronJobInformer.GetIndexer().GetByKey(key)
+		if err != nil {
+			c.logger.Errorf("Unable to get the CronJob trigger %s: %v", key, err)
+			continue
+		}
+		if !exists {
+			c.deleteOrphanCronJob(cronJob, key)
+			continue
+		}
+		if err := c.adoptCronJob(triggerObj.(*cronjobTriggerAPi.CronJobTrigger), cronJob); err != nil {
+			c.logger.Errorf("Unable to adopt the CronJob %s/%s: %v", cronJob.ObjectMeta.Namespace, cronJob.ObjectMeta.Name, err)
+		}
 	}
-	if len(newSlice) == 0 {
-		newSlice = nil
+}
+
+// deleteOrphanCronJob deletes the cron job of a missing trigger, or only reports it in the dry-run mode
+func (c *CronJobTriggerController) deleteOrphanCronJob(cronJob *batchv1beta1.CronJob, key string) {
+	if c.orphanSweepDryRun {
+		c.logger.Warnf("Found the CronJob %s/%s of the missing trigger %s", cronJob.ObjectMeta.Namespace, cronJob.ObjectMeta.Name, key)
+		// The trigger being gone, the event is emitted for the cron job itself
+		if c.recorder != nil {
+			c.recorder.Eventf(cronJob, corev1.EventTypeWarning, eventOrphanedCronJob, "The trigger %s no longer exists, the CronJob would be deleted without the dry-run mode", key)
+		}
+		return
 	}
-	triggerObjClone.ObjectMeta.Finalizers = newSlice
-	err := cronjobutils.UpdateCronJobCustomResource(c.cronjobclient, triggerObjClone)
+	err := c.clientset.BatchV1beta1().CronJobs(cronJob.ObjectMeta.Namespace).Delete(context.TODO(), cronJob.ObjectMeta.Name, metav1.DeleteOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		c.logger.Errorf("Unable to delete the CronJob %s/%s of the missing trigger %s: %v", cronJob.ObjectMeta.Namespace, cronJob.ObjectMeta.Name, key, err)
+		return
+	}
+	c.recordWrite(cronJob.ObjectMeta.Namespace, cronJob.ObjectMeta.Name, "")
+	c.logger.Infof("Deleted the CronJob %s/%s of the missing trigger %s", cronJob.ObjectMeta.Namespace, cronJob.ObjectMeta.Name, key)
+}
+
+// adoptCronJob sets the owner reference of the trigger on its cron job if missing, and enqueues the trigger
+// so that the cron job is updated, or deleted if the trigger doesn't need it anymore. The references to other
+// triggers are replaced while the other owners are kept
+func (c *CronJobTriggerController) adoptCronJob(triggerObj *cronjobTriggerAPi.CronJobTrigger, cronJob *batchv1beta1.CronJob) error {
+	// Removed along with its cron jobs by the finalizer
+	if triggerObj.ObjectMeta.DeletionTimestamp != nil {
+		return nil
+	}
+	for _, owner := range cronJob.ObjectMeta.OwnerReferences {
+		if owner.UID == triggerObj.ObjectMeta.UID {
+			return nil
+		}
+	}
+	or, err := kubelessutils.GetOwnerReference(cronJobObjKind, cronJobAPIVersion, triggerObj.ObjectMeta.Name, triggerObj.ObjectMeta.UID)
+	if err != nil {
+		return err
+	}
+	adopted := cronJob.DeepCopy()
+	adopted.ObjectMeta.OwnerReferences = nil
+	for _, owner := range cronJob.ObjectMeta.OwnerReferences {
+		if !isTriggerReference(owner) {
+			adopted.ObjectMeta.OwnerReferences = append(adopted.ObjectMeta.OwnerReferences, owner)
+		}
+	}
+	adopted.ObjectMeta.OwnerReferences = append(adopted.ObjectMeta.OwnerReferences, or...)
+	adopted, err = c.clientset.BatchV1beta1().CronJobs(cronJob.ObjectMeta.Namespace).Update(context.TODO(), adopted, metav1.UpdateOptions{})
 	if err != nil {
 		return err
 	}
+	c.recordWrite(adopted.ObjectMeta.Namespace, adopted.ObjectMeta.Name, adopted.ObjectMeta.ResourceVersion)
+	c.eventf(triggerObj, corev1.EventTypeNormal, eventCronJobAdopted, "Adopted CronJob %s", cronJob.ObjectMeta.Name)
+	key, err := cache.MetaNamespaceKeyFunc(triggerObj)
+	if err != nil {
+		return err
+	}
+	c.queue.Add(key)
 	return nil
 }
 
+// hasDrifted returns true if the cron jobs of the trigger have been modified or deleted since its last sync
+func (c *CronJobTriggerController) hasDrifted(key string) bool {
+	c.driftedLock.Lock()
+	defer c.driftedLock.Unlock()
+	return c.drifted[key]
+}
+
+// recordWrite records the resource version of a cron job written by the controller, empty if it has been deleted
+func (c *CronJobTriggerController) recordWrite(namespace, name, resourceVersion string) {
+	c.driftedLock.Lock()
+	defer c.driftedLock.Unlock()
+	if c.written == nil {
+		c.written = map[string]string{}
+	}
+	c.written[namespace+"/"+name] = resourceVersion
+}
+
+// isOwnWrite returns true if the given version of the cron job has been written by the controller.
+// The resource version of a deleted cron job changes with its deletion, which is only told apart by its name
+func (c *CronJobTriggerController) isOwnWrite(cronJob *batchv1beta1.CronJob, deleted bool) bool {
+	c.driftedLock.Lock()
+	defer c.driftedLock.Unlock()
+	key := cronJob.ObjectMeta.Namespace + "/" + cronJob.ObjectMeta.Name
+	resourceVersion, ok := c.written[key]
+	if deleted {
+		delete(c.written, key)
+		return ok && resourceVersion == ""
+	}
+	return ok && resourceVersion != "" && resourceVersion == cronJob.ObjectMeta.ResourceVersion
+}
+
+// clearDrift records that the cron jobs of the trigger match its spec again
+func (c *CronJobTriggerController) clearDrift(key string) {
+	c.driftedLock.Lock()
+	defer c.driftedLock.Unlock()
+	delete(c.drifted, key)
+}
+
+// deleteStaleCronJobs removes the cron jobs of the trigger that don't call any of the given functions
+// and reports them in the events of the trigger
+func (c *CronJobTriggerController) deleteStaleCronJobs(triggerObj *cronjobTriggerAPi.CronJobTrigger, functions []*kubelessApi.Function) error {
+	deleted, err := cronjobutils.DeleteStaleCronJobs(c.clientset, triggerObj, functions)
+	for _, name := range deleted {
+		c.recordWrite(triggerObj.ObjectMeta.Namespace, name, "")
+		c.eventf(triggerObj, corev1.EventTypeNormal, eventCronJobDeleted, "Deleted CronJob %s", name)
+	}
+	return err
+}
+
+// eventf emits an event for the trigger, if the controller has an event recorder
+func (c *CronJobTriggerController) eventf(triggerObj *cronjobTriggerAPi.CronJobTrigger, eventType, reason, messageFmt string, args ...interface{}) {
+	if c.recorder == nil {
+		return
+	}
+	c.recorder.Eventf(triggerObj, eventType, reason, messageFmt, args...)
+}
+
+// getExecutionMode returns the execution mode of the trigger, falling back to the one of the controller
+func (c *CronJobTriggerController) getExecutionMode(triggerObj *cronjobTriggerAPi.CronJobTrigger) (string, error) {
+	executionMode := triggerObj.Spec.ExecutionMode
+	if executionMode == "" {
+		executionMode = c.executionMode
+	}
+	switch executionMode {
+	case "", cronjobTriggerAPi.ExecutionModeCronJob:
+		return cronjobTriggerAPi.ExecutionModeCronJob, nil
+	case cronjobTriggerAPi.ExecutionModeController:
+		return executionMode, nil
+	default:
+		return "", fmt.Errorf("Unknown execution mode %s, expecting %s or %s", executionMode, cronjobTriggerAPi.ExecutionModeCronJob, cronjobTriggerAPi.ExecutionModeController)
+	}
+}
+
+// validateSchedule checks that the schedule of the trigger is supported by its execution mode
+// and reports the outcome in the ScheduleValid condition of the trigger
+func (c *CronJobTriggerController) validateSchedule(triggerObj *cronjobTriggerAPi.CronJobTrigger, executionMode string) (bool, error) {
+	var err error
+	message := fmt.Sprintf("Running in the %s execution mode", executionMode)
+	switch {
+	case triggerObj.Spec.RunAt != nil:
+		if triggerObj.Spec.Schedule != "" {
+			err = fmt.Errorf("schedule and runAt can't be specified at the same time")
+		}
+		message = fmt.Sprintf("Running once at %s", triggerObj.Spec.RunAt.UTC().Format(time.RFC3339))
+	default:
+		var schedule string
+		schedule, err = cronjobutils.GetSchedule(triggerObj)
+		if err != nil {
+			break
+		}
+		if schedule != triggerObj.Spec.Schedule {
+			message = fmt.Sprintf("%s with the schedule %q", message, schedule)
+		}
+		if executionMode == cronjobTriggerAPi.ExecutionModeController {
+			_, err = scheduler.ParseSchedule(schedule)
+		} else {
+			err = scheduler.ValidateCronJobSchedule(schedule)
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:    cronjobTriggerAPi.ScheduleValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  "ScheduleAccepted",
+		Message: message,
+	}
+	if err != nil {
+		c.logger.Errorf("Unable to schedule the CronJob trigger %s/%s: %v", triggerObj.Namespace, triggerObj.Name, err)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "InvalidSchedule"
+		condition.Message = err.Error()
+	}
+	if statusErr := c.setCondition(triggerObj, condition); statusErr != nil {
+		return false, statusErr
+	}
+	return err == nil, nil
+}
+
+// runNow creates the jobs of a run requested through the run-now annotation, unless its token was already used
+func (c *CronJobTriggerController) runNow(triggerObj *cronjobTriggerAPi.CronJobTrigger, calls []endpointCall, or []metav1.OwnerReference) error {
+	token := triggerObj.ObjectMeta.Annotations[cronjobTriggerAPi.RunNowAnnotation]
+	if token == "" || (triggerObj.Status.ManualRun != nil && triggerObj.Status.ManualRun.Token == token) {
+		return nil
+	}
+
+	c.logger.Infof("Running CronJob trigger %s/%s on demand", triggerObj.Namespace, triggerObj.Name)
+	manualRun := &cronjobTriggerAPi.ManualRunStatus{
+		Token:     token,
+		StartTime: metav1.Now(),
+	}
+	for _, call := range calls {
+		job, err := cronjobutils.EnsureManualJob(c.clientset, call.functionObj, triggerObj, call.endpoint, c.invokerImage, or, c.imagePullSecrets, token)
+		if err != nil {
+			return err
+		}
+		manualRun.Jobs = append(manualRun.Jobs, job.ObjectMeta.Name)
+	}
+	return c.updateStatus(triggerObj, func(status *cronjobTriggerAPi.CronJobTriggerStatus) {
+		status.ManualRun = manualRun
+	})
+}
+
+// syncSingleRun creates the jobs of a runAt trigger once its time has come and reports when they are finished
+// in the Completed condition of the trigger
+func (c *CronJobTriggerController) syncSingleRun(key string, triggerObj *cronjobTriggerAPi.CronJobTrigger, calls []endpointCall, or []metav1.OwnerReference) error {
+	if c.scheduler != nil {
+		c.scheduler.Remove(key)
+	}
+	// Cron jobs created before switching to runAt are not needed anymore
+	err := c.deleteStaleCronJobs(triggerObj, nil)
+	if err != nil {
+		return err
+	}
+
+	// A completion recorded for a previous value of runAt doesn't count
+	completed := meta.FindStatusCondition(triggerObj.Status.Conditions, cronjobTriggerAPi.Completed)
+	completedRunAt := triggerObj.Status.CompletedRunAt
+	if completed != nil && completed.Status == metav1.ConditionTrue && completedRunAt != nil && completedRunAt.Equal(triggerObj.Spec.RunAt) {
+		return c.deleteFinishedTrigger(key, triggerObj, completed.LastTransitionTime.Time)
+	}
+
+	if wait := time.Until(triggerObj.Spec.RunAt.Time); wait > 0 {
+		c.logger.Infof("CronJob trigger %s will run at %s", key, triggerObj.Spec.RunAt.UTC().Format(time.RFC3339))
+		c.queue.AddAfter(key, wait)
+		return nil
+	}
+
+	var running, failed []string
+	for _, call := range calls {
+		job, err := cronjobutils.EnsureJob(c.clientset, call.functionObj, triggerObj, call.endpoint, c.invokerImage, or, c.imagePullSecrets)
+		if err != nil {
+			return err
+		}
+		finished, succeeded := cronjobutils.JobFinished(job)
+		if !finished {
+			running = append(running, job.ObjectMeta.Name)
+		} else if !succeeded {
+			failed = append(failed, job.ObjectMeta.Name)
+		}
+	}
+	if len(running) != 0 {
+		c.queue.AddAfter(key, singleRunPollPeriod)
+		return c.setCondition(triggerObj, metav1.Condition{
+			Type:    cronjobTriggerAPi.Completed,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Running",
+			Message: fmt.Sprintf("Waiting for the job(s) %s", strings.Join(running, ", ")),
+		})
+	}
+
+	condition := metav1.Condition{
+		Type:    cronjobTriggerAPi.Completed,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Succeeded",
+		Message: fmt.Sprintf("Ran at %s", triggerObj.Spec.RunAt.UTC().Format(time.RFC3339)),
+	}
+	if len(failed) != 0 {
+		condition.Reason = "Failed"
+		condition.Message = fmt.Sprintf("The job(s) %s failed", strings.Join(failed, ", "))
+	}
+	condition.ObservedGeneration = triggerObj.ObjectMeta.Generation
+	runAt := *triggerObj.Spec.RunAt
+	err = c.updateStatus(triggerObj, func(status *cronjobTriggerAPi.CronJobTriggerStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+		status.CompletedRunAt = &runAt
+	})
+	if err != nil {
+		return err
+	}
+	return c.deleteFinishedTrigger(key, triggerObj, time.Now())
+}
+
+// deleteFinishedTrigger deletes the runAt trigger once its TTL after the given completion time has expired
+func (c *CronJobTriggerController) deleteFinishedTrigger(key string, triggerObj *cronjobTriggerAPi.CronJobTrigger, completionTime time.Time) error {
+	if triggerObj.Spec.TTLSecondsAfterFinished == nil {
+		return nil
+	}
+	ttl := time.Duration(*triggerObj.Spec.TTLSecondsAfterFinished) * time.Second
+	if wait := time.Until(completionTime.Add(ttl)); wait > 0 {
+		c.queue.AddAfter(key, wait)
+		return nil
+	}
+	c.logger.Infof("Deleting the completed CronJob trigger %s", key)
+	err := c.cronjobclient.KubelessV1beta1().CronJobTriggers(triggerObj.Namespace).Delete(triggerObj.Name, &metav1.DeleteOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// resolveExclusions returns a copy of the trigger with the exclusions referencing calendars replaced by the days of the calendars
+func (c *CronJobTriggerController) resolveExclusions(triggerObj *cronjobTriggerAPi.CronJobTrigger) (*cronjobTriggerAPi.CronJobTrigger, error) {
+	resolved := false
+	exclusions := []cronjobTriggerAPi.Exclusion{}
+	for _, exclusion := range triggerObj.Spec.Exclusions {
+		if exclusion.Calendar == "" {
+			exclusions = append(exclusions, exclusion)
+			continue
+		}
+		obj, exists, err := c.calendarInformer.GetIndexer().GetByKey(exclusion.Calendar)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("Unable to find the calendar %s", exclusion.Calendar)
+		}
+		days, err := cronjobutils.GetCalendarExclusions(obj.(*cronjobTriggerAPi.TriggerCalendar), exclusion.Name)
+		if err != nil {
+			return nil, err
+		}
+		exclusions = append(exclusions, days...)
+		resolved = true
+	}
+	if !resolved {
+		return triggerObj, nil
+	}
+	resolvedObj := triggerObj.DeepCopy()
+	resolvedObj.Spec.Exclusions = exclusions
+	return resolvedObj, nil
+}
+
+// resolveFollowUps returns the trigger with the function and service targets of its follow-ups replaced by their URL,
+// for the invoker to call them
+func (c *CronJobTriggerController) resolveFollowUps(triggerObj *cronjobTriggerAPi.CronJobTrigger) (*cronjobTriggerAPi.CronJobTrigger, error) {
+	if len(triggerObj.Spec.OnSuccess) == 0 && len(triggerObj.Spec.OnFailure) == 0 {
+		return triggerObj, nil
+	}
+	resolvedObj := triggerObj.DeepCopy()
+	for _, followUps := range [][]cronjobTriggerAPi.FollowUp{resolvedObj.Spec.OnSuccess, resolvedObj.Spec.OnFailure} {
+		for i := range followUps {
+			if err := cronjobutils.ValidateTarget(&followUps[i].Target); err != nil {
+				return nil, fmt.Errorf("Invalid follow-up: %v", err)
+			}
+			endpoint, err := c.getTargetEndpoint(triggerObj, followUps[i].Target)
+			if err != nil {
+				return nil, err
+			}
+			followUps[i].Target = cronjobTriggerAPi.Target{URL: endpoint}
+		}
+	}
+	return resolvedObj, nil
+}
+
+// resolveJobTemplate returns the trigger with its job template applied on top of the default one of the controller
+func (c *CronJobTriggerController) resolveJobTemplate(triggerObj *cronjobTriggerAPi.CronJobTrigger) *cronjobTriggerAPi.CronJobTrigger {
+	if c.jobTemplate == nil {
+		return triggerObj
+	}
+	resolvedObj := triggerObj.DeepCopy()
+	resolvedObj.Spec.JobTemplate = cronjobutils.MergeJobTemplates(c.jobTemplate, triggerObj.Spec.JobTemplate)
+	return resolvedObj
+}
+
+// enqueueCalendarTriggers enqueues the triggers referencing the given calendar
+func (c *CronJobTriggerController) enqueueCalendarTriggers(obj interface{}) {
+	name, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	for _, triggerObj := range c.cronJobInformer.GetStore().List() {
+		cjt := triggerObj.(*cronjobTriggerAPi.CronJobTrigger)
+		for _, exclusion := range cjt.Spec.Exclusions {
+			if exclusion.Calendar != name {
+				continue
+			}
+			key, err := cache.MetaNamespaceKeyFunc(cjt)
+			if err == nil {
+				c.queue.Add(key)
+			}
+			break
+		}
+	}
+}
+
+// recordResult records the outcome of the invocations made by the scheduler in the status of their trigger
+func (c *CronJobTriggerController) recordResult(key string, scheduledTime time.Time, result *invoker.Result) {
+	obj, exists, err := c.cronJobInformer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+	triggerObj := obj.(*cronjobTriggerAPi.CronJobTrigger)
+	run := newTriggerRun(result)
+	var notification *alertNotification
+	var added bool
+	err = c.updateStatus(triggerObj, func(status *cronjobTriggerAPi.CronJobTriggerStatus) {
+		setLastScheduleTime(status, metav1.NewTime(scheduledTime))
+		if result.Skipped != "" {
+			addSkippedRun(status, scheduledTime, result.Skipped)
+		}
+		previousFailures := status.ConsecutiveFailures
+		added = !hasRun(status, run)
+		if added {
+			countFailures(status, run)
+		}
+		addRuns(status, run)
+		notification = setHealth(triggerObj, status, previousFailures)
+	})
+	if err != nil {
+		c.logger.Errorf("Unable to record the run of the CronJob trigger %s: %v", key, err)
+		return
+	}
+	c.sendAlert(triggerObj, notification)
+	if added && result.Skipped == scheduler.SkippedBusy {
+		c.eventf(triggerObj, corev1.EventTypeWarning, eventRunSkipped, "Run scheduled at %s skipped: %s", scheduledTime.UTC().Format(time.RFC3339), result.Skipped)
+	}
+	if added && run.Outcome == cronjobTriggerAPi.RunFailed {
+		c.eventf(triggerObj, corev1.EventTypeWarning, eventInvocationFailed, "Run scheduled at %s failed: %s", scheduledTime.UTC().Format(time.RFC3339), getFailure(run.Message, int(run.StatusCode)))
+		c.sendDeadLetter(triggerObj, run)
+	}
+}
+
+// getFailure describes why a run failed
+func getFailure(message string, statusCode int) string {
+	switch {
+	case message != "":
+		return message
+	case statusCode != 0:
+		return fmt.Sprintf("Received the HTTP status %d", statusCode)
+	default:
+		return "No response received"
+	}
+}
+
+// countFailures updates the number of consecutive failed runs in the status of a trigger with new runs
+func countFailures(status *cronjobTriggerAPi.CronJobTriggerStatus, runs ...cronjobTriggerAPi.TriggerRun) {
+	sorted := append([]cronjobTriggerAPi.TriggerRun{}, runs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return runTime(sorted[i]).Before(runTime(sorted[j]))
+	})
+	for _, run := range sorted {
+		switch run.Outcome {
+		case cronjobTriggerAPi.RunFailed:
+			status.ConsecutiveFailures++
+		case cronjobTriggerAPi.RunSucceeded:
+			status.ConsecutiveFailures = 0
+		}
+	}
+}
+
+// alertNotification is the payload sent to the alerting target of a trigger when its health changes
+type alertNotification struct {
+	Namespace           string                        `json:"namespace"`
+	Trigger             string                        `json:"trigger"`
+	State               string                        `json:"state"`
+	ConsecutiveFailures int32                         `json:"consecutiveFailures"`
+	FailureThreshold    int32                         `json:"failureThreshold"`
+	LastRun             *cronjobTriggerAPi.TriggerRun `json:"lastRun,omitempty"`
+}
+
+// getFailureThreshold returns the number of consecutive failed runs after which the trigger is unhealthy
+func getFailureThreshold(alerting *cronjobTriggerAPi.AlertingPolicy) int32 {
+	if alerting.FailureThreshold > 0 {
+		return alerting.FailureThreshold
+	}
+	return defaultFailureThreshold
+}
+
+// setHealth sets the Healthy condition of a trigger with an alerting policy from its consecutive failed runs,
+// and returns the notification to send if new runs made the number of consecutive failed runs, previously
+// previousFailures, cross the threshold, either up or down
+func setHealth(triggerObj *cronjobTriggerAPi.CronJobTrigger, status *cronjobTriggerAPi.CronJobTriggerStatus, previousFailures int32) *alertNotification {
+	alerting := triggerObj.Spec.Alerting
+	if alerting == nil {
+		meta.RemoveStatusCondition(&status.Conditions, cronjobTriggerAPi.Healthy)
+		return nil
+	}
+	threshold := getFailureThreshold(alerting)
+	wasHealthy := previousFailures < threshold
+	healthy := status.ConsecutiveFailures < threshold
+
+	condition := metav1.Condition{
+		Type:               cronjobTriggerAPi.Healthy,
+		Status:             metav1.ConditionTrue,
+		Reason:             "RunsSucceeding",
+		Message:            fmt.Sprintf("%d consecutive failed run(s), below the threshold of %d", status.ConsecutiveFailures, threshold),
+		ObservedGeneration: triggerObj.ObjectMeta.Generation,
+	}
+	if !healthy {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ConsecutiveFailures"
+		condition.Message = fmt.Sprintf("%d consecutive runs failed", status.ConsecutiveFailures)
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	if healthy == wasHealthy {
+		return nil
+	}
+
+	notification := &alertNotification{
+		Namespace:           triggerObj.ObjectMeta.Namespace,
+		Trigger:             triggerObj.ObjectMeta.Name,
+		State:               cronjobTriggerAPi.AlertFailing,
+		ConsecutiveFailures: status.ConsecutiveFailures,
+		FailureThreshold:    threshold,
+	}
+	if healthy {
+		notification.State = cronjobTriggerAPi.AlertRecovered
+	}
+	for _, run := range status.Runs {
+		if run.Outcome == cronjobTriggerAPi.RunFailed || run.Outcome == cronjobTriggerAPi.RunSucceeded {
+			notification.LastRun = run.DeepCopy()
+			break
+		}
+	}
+	return notification
+}
+
+// syncHealth updates the Healthy condition of the trigger, which changes along with its alerting policy
+func (c *CronJobTriggerController) syncHealth(triggerObj *cronjobTriggerAPi.CronJobTrigger) error {
+	if triggerObj.Spec.Alerting == nil {
+		if meta.FindStatusCondition(triggerObj.Status.Conditions, cronjobTriggerAPi.Healthy) == nil {
+			return nil
+		}
+	} else if err := cronjobutils.ValidateTarget(&triggerObj.Spec.Alerting.Target); err != nil {
+		return fmt.Errorf("Invalid alerting target: %v", err)
+	}
+	err := c.updateStatus(triggerObj, func(status *cronjobTriggerAPi.CronJobTriggerStatus) {
+		// Without new runs, a change of the alerting policy doesn't send a notification
+		setHealth(triggerObj, status, status.ConsecutiveFailures)
+	})
+	if k8sErrors.IsNotFound(err) {
+		// runAt triggers are deleted once finished
+		return nil
+	}
+	return err
+}
+
+// sendAlert queues the notification to be sent to the alerting target of the trigger, and reported in the events
+// of the trigger once sent or given up
+func (c *CronJobTriggerController) sendAlert(triggerObj *cronjobTriggerAPi.CronJobTrigger, notification *alertNotification) {
+	if notification == nil {
+		return
+	}
+	var lastRun string
+	if notification.LastRun != nil {
+		lastRun = runKey(*notification.LastRun)
+	}
+	c.deliver(&delivery{
+		triggerObj:  triggerObj,
+		target:      triggerObj.Spec.Alerting.Target,
+		payload:     notification,
+		eventID:     deliveryEventID(triggerObj, "alert", notification.State, lastRun),
+		description: fmt.Sprintf("the %s notification to the alerting target", notification.State),
+		sentReason:  eventAlertSent,
+		failReason:  eventAlertFailed,
+	})
+}
+
+// deadLetter is the payload sent to the dead-letter target of a trigger for every failed run
+type deadLetter struct {
+	Namespace     string       `json:"namespace"`
+	Trigger       string       `json:"trigger"`
+	Payload       interface{}  `json:"payload,omitempty"`
+	JobName       string       `json:"jobName,omitempty"`
+	ScheduledTime *metav1.Time `json:"scheduledTime,omitempty"`
+	StatusCode    int32        `json:"statusCode,omitempty"`
+	Error         string       `json:"error,omitempty"`
+	Attempts      int32        `json:"attempts,omitempty"`
+	Response      string       `json:"response,omitempty"`
+}
+
+// sendDeadLetter queues the payload of a failed run along with its failure to be sent to the dead-letter target
+// of the trigger, and reported in the events of the trigger once sent or given up
+func (c *CronJobTriggerController) sendDeadLetter(triggerObj *cronjobTriggerAPi.CronJobTrigger, run cronjobTriggerAPi.TriggerRun) {
+	if triggerObj.Spec.DeadLetter == nil {
+		return
+	}
+	letter := deadLetter{
+		Namespace:     triggerObj.ObjectMeta.Namespace,
+		Trigger:       triggerObj.ObjectMeta.Name,
+		Payload:       triggerObj.Spec.Payload,
+		JobName:       run.JobName,
+		ScheduledTime: run.ScheduledTime,
+		StatusCode:    run.StatusCode,
+		Error:         run.Message,
+		Attempts:      run.Attempts,
+		Response:      run.Response,
+	}
+	c.deliver(&delivery{
+		triggerObj:  triggerObj,
+		target:      *triggerObj.Spec.DeadLetter,
+		payload:     letter,
+		eventID:     deliveryEventID(triggerObj, "dead-letter", runKey(run)),
+		description: "the failed run to the dead-letter target",
+		sentReason:  eventDeadLetterSent,
+		failReason:  eventDeadLetterFailed,
+	})
+}
+
+// delivery is a payload waiting to be sent to a target of a trigger
+type delivery struct {
+	triggerObj *cronjobTriggerAPi.CronJobTrigger
+	target     cronjobTriggerAPi.Target
+	payload    interface{}
+	// Identifier sent with every attempt, so the target can ignore the retried ones it received already
+	eventID string
+	// Reported in the logs and the events of the trigger
+	description string
+	sentReason  string
+	failReason  string
+}
+
+// deliveryEventID returns the identifier of a payload sent to a target of the trigger, the same for all its attempts
+func deliveryEventID(triggerObj *cronjobTriggerAPi.CronJobTrigger, kind string, parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(append([]string{string(triggerObj.ObjectMeta.UID), kind}, parts...), "/")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// deliver queues the payload to be sent by the leader, replicas that are not leading anymore give it up
+func (c *CronJobTriggerController) deliver(d *delivery) {
+	deliveries := c.getDeliveries()
+	if deliveries == nil {
+		c.logger.Warnf("Not sending %s of the CronJob trigger %s/%s, this replica is not leading anymore", d.description, d.triggerObj.ObjectMeta.Namespace, d.triggerObj.ObjectMeta.Name)
+		return
+	}
+	deliveries.Add(d)
+}
+
+func (c *CronJobTriggerController) runDeliveryWorker(deliveries workqueue.RateLimitingInterface) {
+	for c.processNextDelivery(deliveries) {
+		// continue looping
+	}
+}
+
+// processNextDelivery sends the next queued payload to its target, and queues it again with a backoff on failure
+func (c *CronJobTriggerController) processNextDelivery(deliveries workqueue.RateLimitingInterface) bool {
+	item, quit := deliveries.Get()
+	if quit {
+		return false
+	}
+	defer deliveries.Done(item)
+
+	d := item.(*delivery)
+	triggerObj := d.triggerObj
+	endpoint, err := c.post(triggerObj, d.target, d.payload, d.eventID)
+	switch {
+	case err == nil:
+		deliveries.Forget(item)
+		c.eventf(triggerObj, corev1.EventTypeNormal, d.sentReason, "Sent %s at %s", d.description, endpoint)
+	case deliveries.NumRequeues(item) < deliveryMaxAttempts-1:
+		c.logger.Warnf("Unable to send %s of the CronJob trigger %s/%s (will retry): %v", d.description, triggerObj.ObjectMeta.Namespace, triggerObj.ObjectMeta.Name, err)
+		deliveries.AddRateLimited(item)
+	default:
+		deliveries.Forget(item)
+		c.logger.Errorf("Unable to send %s of the CronJob trigger %s/%s (giving up): %v", d.description, triggerObj.ObjectMeta.Namespace, triggerObj.ObjectMeta.Name, err)
+		c.eventf(triggerObj, corev1.EventTypeWarning, d.failReason, "Unable to send %s after %d attempts: %v", d.description, deliveryMaxAttempts, err)
+	}
+	return true
+}
+
+// getTargetEndpoint returns the URL of a target in the namespace of the trigger
+func (c *CronJobTriggerController) getTargetEndpoint(triggerObj *cronjobTriggerAPi.CronJobTrigger, target cronjobTriggerAPi.Target) (string, error) {
+	var endpoint string
+	var err error
+	switch {
+	case target.Function != nil:
+		endpoint, _, err = c.getServiceEndpoint(triggerObj, target.Function.Name, target.Function.Port, "")
+	case target.Service != nil:
+		endpoint, _, err = c.getServiceEndpoint(triggerObj, target.Service.Name, target.Service.Port, target.Service.Path)
+	default:
+		endpoint = target.URL
+	}
+	return endpoint, err
+}
+
+// post sends the payload as JSON to a target in the namespace of the trigger and returns the endpoint it was sent to
+func (c *CronJobTriggerController) post(triggerObj *cronjobTriggerAPi.CronJobTrigger, target cronjobTriggerAPi.Target, payload interface{}, eventID string) (string, error) {
+	endpoint, err := c.getTargetEndpoint(triggerObj, target)
+	if err != nil {
+		return "", err
+	}
+	client, err := cronjobutils.GetHTTPClient(c.clientset, triggerObj.ObjectMeta.Namespace, triggerObj.Spec.TLS)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	result := invoker.Invoke(context.TODO(), client, &invoker.Request{
+		URL:            endpoint,
+		Payload:        string(data),
+		EventID:        eventID,
+		TriggerUID:     string(triggerObj.ObjectMeta.UID),
+		TimeoutSeconds: postTimeoutSeconds,
+	})
+	if !result.Succeeded() {
+		return "", fmt.Errorf("%s", getFailure(result.Error, result.StatusCode))
+	}
+	return endpoint, nil
+}
+
+// jobTriggerIndexFunc indexes the jobs by the key of the trigger they make the runs of
+func jobTriggerIndexFunc(obj interface{}) ([]string, error) {
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return nil, nil
+	}
+	name := cronjobutils.GetJobTriggerName(job.ObjectMeta)
+	if name == "" {
+		return nil, nil
+	}
+	return []string{job.ObjectMeta.Namespace + "/" + name}, nil
+}
+
+// enqueueJobTrigger enqueues the trigger the given job makes a run of
+func (c *CronJobTriggerController) enqueueJobTrigger(obj interface{}) {
+	keys, err := jobTriggerIndexFunc(obj)
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		c.queue.Add(key)
+	}
+}
+
+// recordJobRuns records the runs made by the jobs of the trigger in its status
+func (c *CronJobTriggerController) recordJobRuns(triggerObj *cronjobTriggerAPi.CronJobTrigger) error {
+	if c.jobInformer == nil {
+		return nil
+	}
+	key, err := cache.MetaNamespaceKeyFunc(triggerObj)
+	if err != nil {
+		return err
+	}
+	objs, err := c.jobInformer.GetIndexer().ByIndex(triggerIndex, key)
+	if err != nil {
+		return err
+	}
+
+	recorded := map[string]cronjobTriggerAPi.TriggerRun{}
+	for _, run := range triggerObj.Status.Runs {
+		recorded[run.JobName] = run
+	}
+	runs := []cronjobTriggerAPi.TriggerRun{}
+	var lastScheduleTime, lastCompletionTime *metav1.Time
+	watermark := triggerObj.Status.LastCompletionTime
+	for _, obj := range objs {
+		job := obj.(*batchv1.Job)
+		if scheduledTime, ok := cronjobutils.JobScheduledTime(job); ok && (lastScheduleTime == nil || lastScheduleTime.Time.Before(scheduledTime)) {
+			lastScheduleTime = &metav1.Time{Time: scheduledTime}
+		}
+		if run, ok := recorded[job.ObjectMeta.Name]; ok && run.Outcome != cronjobTriggerAPi.RunRunning {
+			// The outcome of finished jobs doesn't change
+			continue
+		}
+		completionTime := jobCompletionTime(job)
+		if completionTime != nil && watermark != nil && completionTime.Before(watermark) {
+			// Recorded already, and dropped from the runs since
+			continue
+		}
+		run, err := c.getJobRun(job)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+		if completionTime != nil && (lastCompletionTime == nil || lastCompletionTime.Before(completionTime)) {
+			lastCompletionTime = completionTime
+		}
+	}
+	scheduled := lastScheduleTime != nil && (triggerObj.Status.LastScheduleTime == nil || triggerObj.Status.LastScheduleTime.Before(lastScheduleTime))
+	if len(runs) == 0 && !scheduled {
+		return nil
+	}
+	var added []cronjobTriggerAPi.TriggerRun
+	var notification *alertNotification
+	err = c.updateStatus(triggerObj, func(status *cronjobTriggerAPi.CronJobTriggerStatus) {
+		if lastScheduleTime != nil {
+			setLastScheduleTime(status, *lastScheduleTime)
+		}
+		if lastCompletionTime != nil && (status.LastCompletionTime == nil || status.LastCompletionTime.Before(lastCompletionTime)) {
+			status.LastCompletionTime = lastCompletionTime
+		}
+		added = nil
+		for _, run := range runs {
+			if hasRun(status, run) {
+				continue
+			}
+			if run.Outcome == cronjobTriggerAPi.RunSkipped && run.ScheduledTime != nil {
+				addSkippedRun(status, run.ScheduledTime.Time, run.Message)
+			}
+			added = append(added, run)
+		}
+		previousFailures := status.ConsecutiveFailures
+		countFailures(status, added...)
+		addRuns(status, runs...)
+		notification = setHealth(triggerObj, status, previousFailures)
+	})
+	if err != nil {
+		return err
+	}
+	c.sendAlert(triggerObj, notification)
+	// Runs recorded already have been reported by a previous sync
+	for _, run := range added {
+		if run.Outcome == cronjobTriggerAPi.RunFailed {
+			c.eventf(triggerObj, corev1.EventTypeWarning, eventInvocationFailed, "Job %s failed: %s", run.JobName, getFailure(run.Message, int(run.StatusCode)))
+			c.sendDeadLetter(triggerObj, run)
+		}
+	}
+	return nil
+}
+
+// getJobRun returns the run made by the job, along with the result of the invoker once the job is finished
+func (c *CronJobTriggerController) getJobRun(job *batchv1.Job) (cronjobTriggerAPi.TriggerRun, error) {
+	run := cronjobTriggerAPi.TriggerRun{Outcome: cronjobTriggerAPi.RunRunning}
+	finished, succeeded := cronjobutils.JobFinished(job)
+	if finished {
+		result, err := cronjobutils.GetJobResult(c.clientset, job)
+		if err != nil {
+			return run, err
+		}
+		if result != nil {
+			run = newTriggerRun(result)
+		} else if succeeded {
+			run.Outcome = cronjobTriggerAPi.RunSucceeded
+		} else {
+			run.Outcome = cronjobTriggerAPi.RunFailed
+		}
+	}
+	run.JobName = job.ObjectMeta.Name
+	if run.StartTime == nil {
+		run.StartTime = job.Status.StartTime
+	}
+	if run.CompletionTime == nil && finished {
+		run.CompletionTime = jobCompletionTime(job)
+	}
+	if run.Duration == nil && run.StartTime != nil && run.CompletionTime != nil {
+		run.Duration = &metav1.Duration{Duration: run.CompletionTime.Sub(run.StartTime.Time)}
+	}
+	return run, nil
+}
+
+// jobCompletionTime returns the time the job finished, nil while it is running
+func jobCompletionTime(job *batchv1.Job) *metav1.Time {
+	if job.Status.CompletionTime != nil {
+		return job.Status.CompletionTime
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return condition.LastTransitionTime.DeepCopy()
+		}
+	}
+	return nil
+}
+
+// newTriggerRun returns the run described by the result of the invoker
+func newTriggerRun(result *invoker.Result) cronjobTriggerAPi.TriggerRun {
+	run := cronjobTriggerAPi.TriggerRun{
+		Outcome:    cronjobTriggerAPi.RunFailed,
+		StatusCode: int32(result.StatusCode),
+		Attempts:   result.Attempts,
+		Message:    result.Error,
+		Response:   result.Response,
+		Latency:    result.Latency,
+	}
+	switch {
+	case result.Skipped != "":
+		run.Outcome = cronjobTriggerAPi.RunSkipped
+		run.Message = result.Skipped
+	case result.Succeeded():
+		run.Outcome = cronjobTriggerAPi.RunSucceeded
+	}
+	for _, followUp := range result.FollowUps {
+		if !followUp.Succeeded() && run.Message == "" {
+			run.Message = fmt.Sprintf("Follow-up call to %s failed: %s", followUp.URL, getFailure(followUp.Error, followUp.StatusCode))
+		}
+	}
+	if result.ScheduledTime != nil {
+		t := metav1.NewTime(*result.ScheduledTime)
+		run.ScheduledTime = &t
+	}
+	if result.StartTime != nil {
+		t := metav1.NewTime(*result.StartTime)
+		run.StartTime = &t
+	}
+	if result.CompletionTime != nil {
+		t := metav1.NewTime(*result.CompletionTime)
+		run.CompletionTime = &t
+	}
+	if result.StartTime != nil && result.CompletionTime != nil {
+		run.Duration = &metav1.Duration{Duration: result.CompletionTime.Sub(*result.StartTime)}
+	}
+	return run
+}
+
+// runKey identifies a run in the status of a trigger, by its job or otherwise by its scheduled time
+func runKey(run cronjobTriggerAPi.TriggerRun) string {
+	if run.JobName != "" || run.ScheduledTime == nil {
+		return run.JobName
+	}
+	return run.ScheduledTime.UTC().Format(time.RFC3339)
+}
+
+// hasRun returns whether the run is already recorded, with the same outcome, in the status of a trigger
+func hasRun(status *cronjobTriggerAPi.CronJobTriggerStatus, run cronjobTriggerAPi.TriggerRun) bool {
+	for _, recorded := range status.Runs {
+		if runKey(recorded) == runKey(run) && recorded.Outcome == run.Outcome {
+			return true
+		}
+	}
+	return false
+}
+
+// addRuns adds or updates runs in the status of a trigger, keeping the latest ones
+func addRuns(status *cronjobTriggerAPi.CronJobTriggerStatus, runs ...cronjobTriggerAPi.TriggerRun) {
+	byKey := map[string]int{}
+	for i, run := range status.Runs {
+		byKey[runKey(run)] = i
+	}
+	for _, run := range runs {
+		if i, ok := byKey[runKey(run)]; ok {
+			status.Runs[i] = run
+			continue
+		}
+		byKey[runKey(run)] = len(status.Runs)
+		status.Runs = append(status.Runs, run)
+	}
+	sort.SliceStable(status.Runs, func(i, j int) bool {
+		return runTime(status.Runs[j]).Before(runTime(status.Runs[i]))
+	})
+	if len(status.Runs) > maxRuns {
+		status.Runs = status.Runs[:maxRuns]
+	}
+}
+
+// runTime returns the time used to order the runs, the scheduled time if known
+func runTime(run cronjobTriggerAPi.TriggerRun) time.Time {
+	if run.ScheduledTime != nil {
+		return run.ScheduledTime.Time
+	}
+	if run.StartTime != nil {
+		return run.StartTime.Time
+	}
+	return time.Time{}
+}
+
+// addSkippedRun adds a skipped run to the status of a trigger, keeping the latest ones
+func addSkippedRun(status *cronjobTriggerAPi.CronJobTriggerStatus, scheduledTime time.Time, reason string) {
+	run := cronjobTriggerAPi.SkippedRun{ScheduledTime: metav1.NewTime(scheduledTime), Reason: reason}
+	status.SkippedRuns = append([]cronjobTriggerAPi.SkippedRun{run}, status.SkippedRuns...)
+	if len(status.SkippedRuns) > maxSkippedRuns {
+		status.SkippedRuns = status.SkippedRuns[:maxSkippedRuns]
+	}
+}
+
+// setLastScheduleTime records the scheduled time of a run in the status of a trigger, unless a later run is recorded already
+func setLastScheduleTime(status *cronjobTriggerAPi.CronJobTriggerStatus, scheduledTime metav1.Time) {
+	if status.LastScheduleTime == nil || status.LastScheduleTime.Before(&scheduledTime) {
+		status.LastScheduleTime = &scheduledTime
+	}
+}
+
+// catchUp makes the runs of the trigger missed since the last scheduled time, following its catch up policy,
+// and records the latest scheduled time in the status of the trigger. The runs are made by jobs, or by the scheduler
+// in the Controller execution mode
+func (c *CronJobTriggerController) catchUp(triggerObj *cronjobTriggerAPi.CronJobTrigger, calls []endpointCall, or []metav1.OwnerReference, lastScheduleTime *metav1.Time, executionMode string) error {
+	if lastScheduleTime == nil {
+		// The trigger never ran, there is nothing to catch up
+		return nil
+	}
+	key, err := cache.MetaNamespaceKeyFunc(triggerObj)
+	if err != nil {
+		return err
+	}
+	latest := *lastScheduleTime
+	missed, err := c.getMissedRuns(triggerObj, calls, lastScheduleTime.Time, time.Now())
+	if err != nil {
+		return err
+	}
+	made, err := c.getJobScheduledTimes(triggerObj)
+	if err != nil {
+		return err
+	}
+	for _, scheduledTime := range missed {
+		if made[scheduledTime.Unix()] {
+			// Made by the CronJob after the last run recorded in the status of the trigger
+			latest = metav1.NewTime(scheduledTime)
+			continue
+		}
+		if executionMode == cronjobTriggerAPi.ExecutionModeController {
+			err = c.scheduler.CatchUp(key, scheduledTime)
+			if err != nil {
+				return err
+			}
+		} else {
+			for _, call := range calls {
+				_, err = cronjobutils.EnsureCatchUpJob(c.clientset, call.functionObj, triggerObj, call.endpoint, c.invokerImage, or, c.imagePullSecrets, scheduledTime)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		c.logger.Infof("Caught up the run of the CronJob trigger %s/%s scheduled at %s", triggerObj.Namespace, triggerObj.Name, scheduledTime.Format(time.RFC3339))
+		latest = metav1.NewTime(scheduledTime)
+	}
+	return c.updateStatus(triggerObj, func(status *cronjobTriggerAPi.CronJobTriggerStatus) {
+		setLastScheduleTime(status, latest)
+	})
+}
+
+// getJobScheduledTimes returns the scheduled times, in seconds, of the runs made by the jobs of the trigger
+func (c *CronJobTriggerController) getJobScheduledTimes(triggerObj *cronjobTriggerAPi.CronJobTrigger) (map[int64]bool, error) {
+	scheduledTimes := map[int64]bool{}
+	if c.jobInformer == nil {
+		return scheduledTimes, nil
+	}
+	key, err := cache.MetaNamespaceKeyFunc(triggerObj)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := c.jobInformer.GetIndexer().ByIndex(triggerIndex, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		if scheduledTime, ok := cronjobutils.JobScheduledTime(obj.(*batchv1.Job)); ok {
+			scheduledTimes[scheduledTime.Unix()] = true
+		}
+	}
+	return scheduledTimes, nil
+}
+
+// getMissedRuns returns the scheduled times of the trigger after the given time that have been missed, oldest first,
+// limited according to the catch up policy of the trigger
+func (c *CronJobTriggerController) getMissedRuns(triggerObj *cronjobTriggerAPi.CronJobTrigger, calls []endpointCall, since, now time.Time) ([]time.Time, error) {
+	var limit int
+	switch triggerObj.Spec.CatchUp {
+	case "", cronjobTriggerAPi.CatchUpNone:
+		return nil, nil
+	case cronjobTriggerAPi.CatchUpLastOnly:
+		limit = 1
+	case cronjobTriggerAPi.CatchUpAll:
+		limit = defaultCatchUpLimit
+		if triggerObj.Spec.CatchUpLimit > 0 {
+			limit = int(triggerObj.Spec.CatchUpLimit)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown catch up policy %s, expecting %s, %s or %s", triggerObj.Spec.CatchUp, cronjobTriggerAPi.CatchUpNone, cronjobTriggerAPi.CatchUpLastOnly, cronjobTriggerAPi.CatchUpAll)
+	}
+
+	spec, err := cronjobutils.GetSchedule(triggerObj)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := scheduler.ParseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Runs may still be in progress until the deadline of their requests
+	grace := time.Duration(0)
+	for _, call := range calls {
+		request, err := cronjobutils.GetInvocationRequest(call.functionObj, triggerObj, call.endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if request.Deadline() > grace {
+			grace = request.Deadline()
+		}
+	}
+	until := now.Add(-grace - catchUpGracePeriod)
+
+	missed := []time.Time{}
+	for t := schedule.Next(since); !t.IsZero() && !t.After(until); t = schedule.Next(t) {
+		missed = append(missed, t)
+		if len(missed) > limit {
+			missed = missed[1:]
+		}
+	}
+	return missed, nil
+}
+
+// scheduleInvocations makes the calls of the trigger from the scheduler of the controller
+func (c *CronJobTriggerController) scheduleInvocations(key string, triggerObj *cronjobTriggerAPi.CronJobTrigger, calls []endpointCall) error {
+	if c.scheduler == nil {
+		return fmt.Errorf("The %s execution mode is not available in this controller", cronjobTriggerAPi.ExecutionModeController)
+	}
+	client, err := cronjobutils.GetHTTPClient(c.clientset, triggerObj.ObjectMeta.Namespace, triggerObj.Spec.TLS)
+	if err != nil {
+		return err
+	}
+	invocations := []scheduler.Invocation{}
+	for _, call := range calls {
+		request, err := cronjobutils.GetInvocationRequest(call.functionObj, triggerObj, call.endpoint)
+		if err != nil {
+			return err
+		}
+		invocations = append(invocations, scheduler.Invocation{Request: request, Client: client})
+	}
+	schedule, err := cronjobutils.GetSchedule(triggerObj)
+	if err != nil {
+		return err
+	}
+	return c.scheduler.Set(key, schedule, invocations)
+}
+
+// resolveServiceEndpoint finds the URL of the target service in the namespace of the trigger and
+// reports the outcome in the ServiceAvailable condition of the trigger
+func (c *CronJobTriggerController) resolveServiceEndpoint(triggerObj *cronjobTriggerAPi.CronJobTrigger, name, port, path string) (string, error) {
+	endpoint, reason, err := c.getServiceEndpoint(triggerObj, name, port, path)
+	if err != nil {
+		if reason == "" {
+			return "", err
+		}
+		c.logger.Errorf("Unable to find the endpoint of the service %s in the namespace %s: %v", name, triggerObj.ObjectMeta.Namespace, err)
+		condition := metav1.Condition{
+			Type:    cronjobTriggerAPi.ServiceAvailable,
+			Status:  metav1.ConditionFalse,
+			Reason:  reason,
+			Message: err.Error(),
+		}
+		if statusErr := c.setCondition(triggerObj, condition); statusErr != nil {
+			c.logger.Errorf("Failed to update the status of the CronJob trigger %s/%s: %v", triggerObj.Namespace, triggerObj.Name, statusErr)
+		}
+		return "", err
+	}
+
+	condition := metav1.Condition{
+		Type:    cronjobTriggerAPi.ServiceAvailable,
+		Status:  metav1.ConditionTrue,
+		Reason:  "ServiceFound",
+		Message: fmt.Sprintf("Calling the service at %s", endpoint),
+	}
+	if err := c.setCondition(triggerObj, condition); err != nil {
+		return "", err
+	}
+	return endpoint, nil
+}
+
+// getServiceEndpoint returns the URL of a service in the namespace of the trigger.
+// When the service can't be called, the reason is returned along with the error.
+func (c *CronJobTriggerController) getServiceEndpoint(triggerObj *cronjobTriggerAPi.CronJobTrigger, name, port, path string) (string, string, error) {
+	scheme, err := cronjobutils.GetURLScheme(triggerObj.Spec.TLS)
+	if err != nil {
+		return "", "", err
+	}
+
+	ns := triggerObj.ObjectMeta.Namespace
+	obj, exists, err := c.serviceInformer.GetIndexer().GetByKey(ns + "/" + name)
+	if err != nil {
+		return "", "", err
+	}
+	if !exists {
+		return "", "ServiceNotFound", fmt.Errorf("Service %s/%s not found", ns, name)
+	}
+	svc := obj.(*corev1.Service)
+
+	endpoint, err := cronjobutils.GetServiceEndpoint(svc, port, path, scheme, c.clusterDomain)
+	if err != nil {
+		return "", "PortNotFound", err
+	}
+	return endpoint, "", nil
+}
+
+// serviceTriggerIndexFunc indexes the triggers by the services they call, the services of the functions being named
+// after them. The services of the functions picked by a selector are not indexed
+func serviceTriggerIndexFunc(obj interface{}) ([]string, error) {
+	triggerObj, ok := obj.(*cronjobTriggerAPi.CronJobTrigger)
+	if !ok {
+		return nil, nil
+	}
+	ns := triggerObj.ObjectMeta.Namespace
+	var keys []string
+	addTarget := func(target *cronjobTriggerAPi.Target) {
+		switch {
+		case target == nil:
+		case target.Function != nil:
+			keys = append(keys, ns+"/"+target.Function.Name)
+		case target.Service != nil:
+			keys = append(keys, ns+"/"+target.Service.Name)
+		}
+	}
+	if triggerObj.Spec.FunctionName != "" {
+		keys = append(keys, ns+"/"+triggerObj.Spec.FunctionName)
+	}
+	addTarget(triggerObj.Spec.Target)
+	addTarget(triggerObj.Spec.DeadLetter)
+	if triggerObj.Spec.Alerting != nil {
+		addTarget(&triggerObj.Spec.Alerting.Target)
+	}
+	for i := range triggerObj.Spec.OnSuccess {
+		addTarget(&triggerObj.Spec.OnSuccess[i].Target)
+	}
+	for i := range triggerObj.Spec.OnFailure {
+		addTarget(&triggerObj.Spec.OnFailure[i].Target)
+	}
+	return keys, nil
+}
+
+// enqueueServiceTriggers enqueues the triggers calling the given service, including the ones selecting its function
+func (c *CronJobTriggerController) enqueueServiceTriggers(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return
+	}
+	key := svc.ObjectMeta.Namespace + "/" + svc.ObjectMeta.Name
+	triggers, err := c.cronJobInformer.GetIndexer().ByIndex(serviceIndex, key)
+	if err != nil {
+		c.logger.Errorf("Unable to find the triggers calling the service %s: %v", key, err)
+		return
+	}
+	for _, triggerObj := range triggers {
+		if triggerKey, err := cache.MetaNamespaceKeyFunc(triggerObj); err == nil {
+			c.queue.Add(triggerKey)
+		}
+	}
+	functionObj, exists, err := c.functionInformer.GetIndexer().GetByKey(key)
+	if err == nil && exists {
+		c.enqueueSelectingTriggers(functionObj.(*kubelessApi.Function))
+	}
+}
+
+func (c *CronJobTriggerController) functionAddedDeletedUpdated(obj interface{}, deleted bool) error {
+	functionObj, ok := obj.(*kubelessApi.Function)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			err := fmt.Errorf("Couldn't get object from tombstone %#v", obj)
+			c.logger.Errorf(err.Error())
+			return err
+		}
+		functionObj, ok = tombstone.Obj.(*kubelessApi.Function)
+		if !ok {
+			err := fmt.Errorf("Tombstone contained object that is not a Pod %#v", obj)
+			c.logger.Errorf(err.Error())
+			return err
+		}
+	}
+
+	c.logger.Infof("Processing update to function object %s Namespace: %s", functionObj.Name, functionObj.Namespace)
+	if err := c.enqueueSelectingTriggers(functionObj); err != nil {
+		return err
+	}
+	if deleted {
+		c.logger.Infof("Function %s deleted. Removing associated cronjob trigger", functionObj.Name)
+		triggers, err := c.cronJobInformer.GetIndexer().ByIndex(serviceIndex, functionObj.Namespace+"/"+functionObj.Name)
+		if err != nil {
+			return err
+		}
+		for _, obj := range triggers {
+			cjt := obj.(*cronjobTriggerAPi.CronJobTrigger)
+			// Only triggers calling the function depend on it, not the ones calling it as a follow-up
+			target, err := cronjobutils.GetTarget(&cjt.Spec)
+			if err != nil || target == nil || target.Function == nil {
+				continue
+			}
+			if target.Function.Name == functionObj.Name {
+				err = c.cronjobclient.KubelessV1beta1().CronJobTriggers(functionObj.Namespace).Delete(cjt.Name, &metav1.DeleteOptions{})
+				if err != nil && !k8sErrors.IsNotFound(err) {
+					c.logger.Errorf("Failed to delete cronjobtrigger created for the function %s in namespace %s, Error: %s", functionObj.ObjectMeta.Name, functionObj.ObjectMeta.Namespace, err)
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// enqueueSelectingTriggers queues the triggers whose function selector matches the function,
+// so their cron jobs follow the functions as they come and go
+func (c *CronJobTriggerController) enqueueSelectingTriggers(functionObj *kubelessApi.Function) error {
+	triggers, err := c.cronJobInformer.GetIndexer().ByIndex(selectorIndex, functionObj.Namespace)
+	if err != nil {
+		return err
+	}
+	for _, obj := range triggers {
+		cjt := obj.(*cronjobTriggerAPi.CronJobTrigger)
+		selector, err := metav1.LabelSelectorAsSelector(cjt.Spec.FunctionSelector)
+		if err != nil || !selector.Matches(labels.Set(functionObj.ObjectMeta.Labels)) {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(cjt)
+		if err == nil {
+			c.queue.Add(key)
+		}
+	}
+	return nil
+}
+
+// selectorTriggerIndexFunc indexes the triggers selecting their functions by their namespace
+func selectorTriggerIndexFunc(obj interface{}) ([]string, error) {
+	triggerObj, ok := obj.(*cronjobTriggerAPi.CronJobTrigger)
+	if !ok || triggerObj.Spec.FunctionSelector == nil {
+		return nil, nil
+	}
+	return []string{triggerObj.ObjectMeta.Namespace}, nil
+}
+
+func (c *CronJobTriggerController) cronJobTriggerObjHasFinalizer(triggerObj *cronjobTriggerAPi.CronJobTrigger) bool {
+	currentFinalizers := triggerObj.ObjectMeta.Finalizers
+	for _, f := range currentFinalizers {
+		if f == cronJobTriggerFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CronJobTriggerController) cronJobTriggerObjAddFinalizer(triggercObj *cronjobTriggerAPi.CronJobTrigger) error {
+	triggercObjClone := triggercObj.DeepCopy()
+	triggercObjClone.ObjectMeta.Finalizers = append(triggercObjClone.ObjectMeta.Finalizers, cronJobTriggerFinalizer)
+	return cronjobutils.UpdateCronJobCustomResource(c.cronjobclient, triggercObjClone)
+}
+
+func (c *CronJobTriggerController) cronJobTriggerObjRemoveFinalizer(triggercObj *cronjobTriggerAPi.CronJobTrigger) error {
+	triggerObjClone := triggercObj.DeepCopy()
+	newSlice := make([]string, 0)
+	for _, item := range triggerObjClone.ObjectMeta.Finalizers {
+		if item == cronJobTriggerFinalizer {
+			continue
+		}
+		newSlice = append(newSlice, item)
+	}
+	if len(newSlice) == 0 {
+		newSlice = nil
+	}
+	triggerObjClone.ObjectMeta.Finalizers = newSlice
+	err := cronjobutils.UpdateCronJobCustomResource(c.cronjobclient, triggerObjClone)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// setCondition records the condition in the status of the trigger, unless it's already there
+func (c *CronJobTriggerController) setCondition(triggerObj *cronjobTriggerAPi.CronJobTrigger, condition metav1.Condition) error {
+	current := meta.FindStatusCondition(triggerObj.Status.Conditions, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason && current.Message == condition.Message {
+		return nil
+	}
+	condition.ObservedGeneration = triggerObj.ObjectMeta.Generation
+	return c.updateStatus(triggerObj, func(status *cronjobTriggerAPi.CronJobTriggerStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	})
+}
+
+// updateStatus applies the given change to the status of the latest version of the trigger
+func (c *CronJobTriggerController) updateStatus(triggerObj *cronjobTriggerAPi.CronJobTrigger, update func(status *cronjobTriggerAPi.CronJobTriggerStatus)) error {
+	latestObj, err := cronjobutils.GetCronJobCustomResource(c.cronjobclient, triggerObj.Name, triggerObj.Namespace)
+	if err != nil {
+		return err
+	}
+	newObj := latestObj.DeepCopy()
+	update(&newObj.Status)
+	if equality.Semantic.DeepEqual(latestObj.Status, newObj.Status) {
+		return nil
+	}
+	return cronjobutils.UpdateCronJobCustomResource(c.cronjobclient, newObj)
+}
+
 func cronJobTriggerObjChanged(oldObj, newObj *cronjobTriggerAPi.CronJobTrigger) bool {
 	// If the CronJob trigger object's deletion timestamp is set, then process
 	if oldObj.DeletionTimestamp != newObj.DeletionTimestamp {
@@ -1,16 +1,36 @@
 package controller
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 
 	cronjobtriggerapi "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
 	cronjobTriggerFake "github.com/kubeless/cronjob-trigger/pkg/client/clientset/versioned/fake"
+	triggerscheme "github.com/kubeless/cronjob-trigger/pkg/client/clientset/versioned/scheme"
+	"github.com/kubeless/cronjob-trigger/pkg/invoker"
+	"github.com/kubeless/cronjob-trigger/pkg/scheduler"
+	"github.com/kubeless/cronjob-trigger/pkg/testutil"
+	cronjobutils "github.com/kubeless/cronjob-trigger/pkg/utils"
 	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
 	"github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
 	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 )
 
 func TestFunctionAddedUpdated(t *testing.T) {
@@ -27,18 +47,10 @@ func TestFunctionAddedUpdated(t *testing.T) {
 		ObjectMeta: myNsFoo,
 	}
 
-	triggerClientset := cronjobTriggerFake.NewSimpleClientset(&cjtrigger)
-
 	cronjob := batchv1beta1.CronJob{
 		ObjectMeta: myNsFoo,
 	}
-	clientset := fake.NewSimpleClientset(&cronjob)
-
-	controller := CronJobTriggerController{
-		clientset:     clientset,
-		cronjobclient: triggerClientset,
-		logger:        logrus.WithField("controller", "cronjob-trigger-controller"),
-	}
+	controller, _, _ := newTestController(&cjtrigger, &cronjob)
 
 	// no-op for when the function is not deleted
 	err := controller.functionAddedDeletedUpdated(&f, false)
@@ -75,18 +87,10 @@ func TestFunctionDeleted(t *testing.T) {
 		},
 	}
 
-	triggerClientset := cronjobTriggerFake.NewSimpleClientset(&cjtrigger)
-
 	cronjob := batchv1beta1.CronJob{
 		ObjectMeta: myNsFoo,
 	}
-	clientset := fake.NewSimpleClientset(&cronjob)
-
-	controller := CronJobTriggerController{
-		clientset:     clientset,
-		cronjobclient: triggerClientset,
-		logger:        logrus.WithField("controller", "cronjob-trigger-controller"),
-	}
+	controller, _, _ := newTestController(&cjtrigger, &cronjob)
 
 	// no-op for when the function is not deleted
 	err := controller.functionAddedDeletedUpdated(&f, true)
@@ -149,3 +153,1620 @@ func TestCronJobTriggerObjChanged(t *testing.T) {
 		}
 	}
 }
+
+func TestSetCondition(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "foo-trigger",
+		},
+	}
+	controller, _, triggerClientset := newTestController(&cjtrigger)
+
+	condition := metav1.Condition{
+		Type:    cronjobtriggerapi.ServiceAvailable,
+		Status:  metav1.ConditionFalse,
+		Reason:  "ServiceNotFound",
+		Message: "Service myns/foo of the function not found",
+	}
+	err := controller.setCondition(&cjtrigger, condition)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("foo-trigger", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(updated.Status.Conditions) != 1 {
+		t.Fatalf("Unexpected conditions: %v", updated.Status.Conditions)
+	}
+	found := updated.Status.Conditions[0]
+	if found.Type != condition.Type || found.Status != condition.Status || found.Reason != condition.Reason || found.LastTransitionTime.IsZero() {
+		t.Errorf("Unexpected condition %+v", found)
+	}
+
+	// Setting the same condition again should not update the trigger
+	triggerClientset.ClearActions()
+	err = controller.setCondition(updated, condition)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(triggerClientset.Actions()) != 0 {
+		t.Errorf("Unexpected actions: %v", triggerClientset.Actions())
+	}
+}
+
+func TestSyncSelectedFunctions(t *testing.T) {
+	nightly := map[string]string{"schedule": "nightly"}
+	functions := []*kubelessApi.Function{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "cleanup-a", Labels: nightly}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "cleanup-b", Labels: nightly}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "report", Labels: map[string]string{"schedule": "weekly"}}},
+	}
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "nightly",
+			UID:       "nightly-uid",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:         "0 0 * * *",
+			FunctionSelector: &metav1.LabelSelector{MatchLabels: nightly},
+		},
+	}
+	or := []metav1.OwnerReference{{Kind: "CronJobTrigger", Name: "nightly", UID: "nightly-uid"}}
+	// Left behind by a function that is not selected anymore
+	staleCronJob := batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "myns",
+			Name:            "trigger-nightly-old",
+			OwnerReferences: or,
+		},
+	}
+	controller, clientset, triggerClientset := newTestController(
+		&cjtrigger,
+		functions[0], functions[1], functions[2],
+		&staleCronJob,
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "cleanup-a"}},
+	)
+	testutil.PrependApplyReactor(clientset)
+
+	err := controller.syncCronJobTrigger("myns/nightly")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cronJobs, err := clientset.BatchV1beta1().CronJobs("myns").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cronJobs.Items) != 1 || cronJobs.Items[0].ObjectMeta.Name != cronjobutils.GetCronJobName(functions[0], &cjtrigger) {
+		t.Errorf("Unexpected cron jobs: %v", cronJobs.Items)
+	}
+
+	// cleanup-b has no service yet
+	updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("nightly", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	condition := meta.FindStatusCondition(updated.Status.Conditions, cronjobtriggerapi.ServiceAvailable)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != "ServiceNotFound" {
+		t.Errorf("Unexpected condition %+v", condition)
+	}
+
+	// Functions gaining the label are picked up by the trigger, found in the cache
+	triggerClientset.ClearActions()
+	controller.functionAddedDeletedUpdated(functions[1], false)
+	controller.functionAddedDeletedUpdated(functions[2], false)
+	if controller.queue.Len() != 1 {
+		t.Errorf("Unexpected number of queued triggers: %d", controller.queue.Len())
+	}
+	if len(triggerClientset.Actions()) != 0 {
+		t.Errorf("Unexpected actions: %v", triggerClientset.Actions())
+	}
+}
+
+func TestSyncControllerExecutionMode(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "webhook",
+			UID:       "webhook-uid",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:      "*/5 * * * *",
+			Target:        &cronjobtriggerapi.Target{URL: "https://example.com/hook"},
+			ExecutionMode: cronjobtriggerapi.ExecutionModeController,
+		},
+	}
+	// Created while the trigger was running in the CronJob execution mode
+	cronjob := batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "myns",
+			Name:            "trigger-webhook",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "CronJobTrigger", Name: "webhook", UID: "webhook-uid"}},
+		},
+	}
+	controller, clientset, _ := newTestController(&cjtrigger, &cronjob)
+	testutil.PrependApplyReactor(clientset)
+
+	// Without scheduler the trigger can't be processed
+	if err := controller.syncCronJobTrigger("myns/webhook"); err == nil {
+		t.Errorf("Expecting an error without scheduler")
+	}
+
+	controller.scheduler = scheduler.New(1)
+	if err := controller.syncCronJobTrigger("myns/webhook"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := controller.scheduler.Next("myns/webhook"); !ok {
+		t.Errorf("The trigger should be scheduled")
+	}
+	cronJobs, err := clientset.BatchV1beta1().CronJobs("myns").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cronJobs.Items) != 0 {
+		t.Errorf("Unexpected cron jobs: %v", cronJobs.Items)
+	}
+
+	// Switching back to the CronJob execution mode
+	cjtrigger.Spec.ExecutionMode = cronjobtriggerapi.ExecutionModeCronJob
+	controller.cronJobInformer.GetIndexer().Update(&cjtrigger)
+	if err := controller.syncCronJobTrigger("myns/webhook"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := controller.scheduler.Next("myns/webhook"); ok {
+		t.Errorf("The trigger should not be scheduled anymore")
+	}
+	if _, err := clientset.BatchV1beta1().CronJobs("myns").Get(context.TODO(), cronjobutils.GetCronJobName(nil, &cjtrigger), metav1.GetOptions{}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestSyncUnsupportedSchedule(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "healthcheck",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule: "@every 15s",
+			Target:   &cronjobtriggerapi.Target{URL: "https://example.com/health"},
+		},
+	}
+	controller, clientset, triggerClientset := newTestController(&cjtrigger)
+	controller.scheduler = scheduler.New(1)
+
+	// Intervals can't be run by CronJobs
+	if err := controller.syncCronJobTrigger("myns/healthcheck"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cronJobs, err := clientset.BatchV1beta1().CronJobs("myns").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cronJobs.Items) != 0 {
+		t.Errorf("Unexpected cron jobs: %v", cronJobs.Items)
+	}
+	updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("healthcheck", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	condition := meta.FindStatusCondition(updated.Status.Conditions, cronjobtriggerapi.ScheduleValid)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != "InvalidSchedule" {
+		t.Errorf("Unexpected condition %+v", condition)
+	}
+
+	// They are supported by the Controller execution mode
+	cjtrigger.Spec.ExecutionMode = cronjobtriggerapi.ExecutionModeController
+	controller.cronJobInformer.GetIndexer().Update(&cjtrigger)
+	if err := controller.syncCronJobTrigger("myns/healthcheck"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := controller.scheduler.Next("myns/healthcheck"); !ok {
+		t.Errorf("The trigger should be scheduled")
+	}
+}
+
+func TestSyncSingleRun(t *testing.T) {
+	runAt := metav1.NewTime(time.Now().Add(-time.Minute))
+	ttl := int32(0)
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "launch",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			RunAt:                   &runAt,
+			TTLSecondsAfterFinished: &ttl,
+			Target:                  &cronjobtriggerapi.Target{URL: "https://example.com/launch"},
+		},
+	}
+	controller, clientset, triggerClientset := newTestController(&cjtrigger)
+
+	if err := controller.syncCronJobTrigger("myns/launch"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	jobs, err := clientset.BatchV1().Jobs("myns").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(jobs.Items) != 1 {
+		t.Fatalf("Unexpected jobs: %v", jobs.Items)
+	}
+
+	// The trigger is completed and deleted once the job succeeds
+	job := jobs.Items[0]
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	clientset.BatchV1().Jobs("myns").UpdateStatus(context.TODO(), &job, metav1.UpdateOptions{})
+	if err := controller.syncCronJobTrigger("myns/launch"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("launch", metav1.GetOptions{}); !k8sErrors.IsNotFound(err) {
+		t.Errorf("The completed trigger should be deleted, received %v", err)
+	}
+}
+
+func TestSyncSingleRunRescheduled(t *testing.T) {
+	// The trigger completed at a later time than its new runAt, for a previous value of runAt
+	runAt := metav1.NewTime(time.Now().Add(-time.Hour))
+	completedRunAt := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "launch",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			RunAt:  &runAt,
+			Target: &cronjobtriggerapi.Target{URL: "https://example.com/launch"},
+		},
+		Status: cronjobtriggerapi.CronJobTriggerStatus{
+			Conditions: []metav1.Condition{{
+				Type:               cronjobtriggerapi.Completed,
+				Status:             metav1.ConditionTrue,
+				LastTransitionTime: metav1.Now(),
+				Reason:             "Succeeded",
+			}},
+			CompletedRunAt: &completedRunAt,
+		},
+	}
+	controller, clientset, triggerClientset := newTestController(&cjtrigger)
+
+	if err := controller.syncCronJobTrigger("myns/launch"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	jobs, err := clientset.BatchV1().Jobs("myns").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(jobs.Items) != 1 {
+		t.Fatalf("Expecting the new runAt to be run, got the jobs %v", jobs.Items)
+	}
+
+	job := jobs.Items[0]
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	clientset.BatchV1().Jobs("myns").UpdateStatus(context.TODO(), &job, metav1.UpdateOptions{})
+	if err := controller.syncCronJobTrigger("myns/launch"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("launch", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if updated.Status.CompletedRunAt == nil || !updated.Status.CompletedRunAt.Equal(&runAt) {
+		t.Errorf("Unexpected completed runAt %v", updated.Status.CompletedRunAt)
+	}
+}
+
+func TestRunNow(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "myns",
+			Name:        "report",
+			Annotations: map[string]string{cronjobtriggerapi.RunNowAnnotation: "first"},
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:      "0 0 * * *",
+			Target:        &cronjobtriggerapi.Target{URL: "https://example.com/report"},
+			ExecutionMode: cronjobtriggerapi.ExecutionModeController,
+		},
+	}
+	controller, clientset, triggerClientset := newTestController(&cjtrigger)
+	controller.scheduler = scheduler.New(1)
+
+	sync := func() *cronjobtriggerapi.CronJobTrigger {
+		if err := controller.syncCronJobTrigger("myns/report"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("report", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		controller.cronJobInformer.GetIndexer().Update(updated)
+		return updated
+	}
+	countJobs := func() int {
+		jobs, err := clientset.BatchV1().Jobs("myns").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return len(jobs.Items)
+	}
+
+	updated := sync()
+	if updated.Status.ManualRun == nil || updated.Status.ManualRun.Token != "first" || len(updated.Status.ManualRun.Jobs) != 1 {
+		t.Errorf("Unexpected manual run %+v", updated.Status.ManualRun)
+	}
+	if countJobs() != 1 {
+		t.Errorf("Unexpected number of jobs: %d", countJobs())
+	}
+
+	// The same token doesn't run the trigger again
+	sync()
+	if countJobs() != 1 {
+		t.Errorf("Unexpected number of jobs: %d", countJobs())
+	}
+
+	updated.ObjectMeta.Annotations[cronjobtriggerapi.RunNowAnnotation] = "second"
+	controller.cronJobInformer.GetIndexer().Update(updated)
+	updated = sync()
+	if updated.Status.ManualRun.Token != "second" || countJobs() != 2 {
+		t.Errorf("Unexpected manual run %+v with %d jobs", updated.Status.ManualRun, countJobs())
+	}
+
+	// A trigger with an invalid schedule can still be run on demand
+	updated.Spec.Schedule = "not a schedule"
+	updated.ObjectMeta.Annotations[cronjobtriggerapi.RunNowAnnotation] = "third"
+	controller.cronJobInformer.GetIndexer().Update(updated)
+	updated = sync()
+	if updated.Status.ManualRun.Token != "third" || countJobs() != 3 {
+		t.Errorf("Unexpected manual run %+v with %d jobs", updated.Status.ManualRun, countJobs())
+	}
+	condition := meta.FindStatusCondition(updated.Status.Conditions, cronjobtriggerapi.ScheduleValid)
+	if condition == nil || condition.Status != metav1.ConditionFalse {
+		t.Errorf("Unexpected condition %+v", condition)
+	}
+}
+
+func TestSyncWithCalendar(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "billing",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:      "0 * * * *",
+			Target:        &cronjobtriggerapi.Target{URL: "https://example.com/billing"},
+			ExecutionMode: cronjobtriggerapi.ExecutionModeController,
+			Exclusions:    []cronjobtriggerapi.Exclusion{{Calendar: "holidays"}},
+		},
+	}
+	controller, _, triggerClientset := newTestController(&cjtrigger)
+	controller.scheduler = scheduler.New(1)
+
+	if err := controller.syncCronJobTrigger("myns/billing"); err == nil {
+		t.Errorf("Expecting an error while the calendar doesn't exist")
+	}
+
+	calendar := &cronjobtriggerapi.TriggerCalendar{
+		ObjectMeta: metav1.ObjectMeta{Name: "holidays"},
+		Spec:       cronjobtriggerapi.TriggerCalendarSpec{Dates: []string{"2018-12-25"}},
+	}
+	controller.calendarInformer.GetIndexer().Add(calendar)
+	controller.enqueueCalendarTriggers(calendar)
+	if controller.queue.Len() != 1 {
+		t.Errorf("The trigger referencing the calendar should be enqueued")
+	}
+	if err := controller.syncCronJobTrigger("myns/billing"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Skipped runs are recorded in the status of the trigger
+	scheduledTime := time.Date(2018, 12, 25, 10, 0, 0, 0, time.UTC)
+	controller.recordResult("myns/billing", scheduledTime, &invoker.Result{Skipped: "Excluded by the calendar holidays"})
+	updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("billing", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(updated.Status.SkippedRuns) != 1 || !updated.Status.SkippedRuns[0].ScheduledTime.Time.Equal(scheduledTime) {
+		t.Errorf("Unexpected skipped runs %+v", updated.Status.SkippedRuns)
+	}
+}
+
+func TestGetMissedRuns(t *testing.T) {
+	cjtrigger := &cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "hourly"},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule: "0 * * * *",
+			Target:   &cronjobtriggerapi.Target{URL: "https://example.com/hourly"},
+		},
+	}
+	calls := []endpointCall{{endpoint: "https://example.com/hourly"}}
+	controller := CronJobTriggerController{}
+	since := time.Date(2018, 3, 5, 5, 0, 0, 0, time.UTC)
+	// The run of 10:00 may still be in progress
+	now := time.Date(2018, 3, 5, 10, 1, 0, 0, time.UTC)
+
+	expected := map[string][]time.Time{
+		"":                                nil,
+		cronjobtriggerapi.CatchUpNone:     nil,
+		cronjobtriggerapi.CatchUpLastOnly: {time.Date(2018, 3, 5, 9, 0, 0, 0, time.UTC)},
+		cronjobtriggerapi.CatchUpAll: {
+			time.Date(2018, 3, 5, 6, 0, 0, 0, time.UTC),
+			time.Date(2018, 3, 5, 7, 0, 0, 0, time.UTC),
+			time.Date(2018, 3, 5, 8, 0, 0, 0, time.UTC),
+			time.Date(2018, 3, 5, 9, 0, 0, 0, time.UTC),
+		},
+	}
+	for policy, runs := range expected {
+		cjtrigger.Spec.CatchUp = policy
+		missed, err := controller.getMissedRuns(cjtrigger, calls, since, now)
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", policy, err)
+		}
+		if len(missed) != len(runs) {
+			t.Errorf("Unexpected missed runs for %q: %v", policy, missed)
+			continue
+		}
+		for i := range runs {
+			if !missed[i].Equal(runs[i]) {
+				t.Errorf("Unexpected missed runs for %q: %v", policy, missed)
+			}
+		}
+	}
+
+	cjtrigger.Spec.CatchUp = cronjobtriggerapi.CatchUpAll
+	cjtrigger.Spec.CatchUpLimit = 2
+	missed, _ := controller.getMissedRuns(cjtrigger, calls, since, now)
+	if len(missed) != 2 || !missed[0].Equal(time.Date(2018, 3, 5, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected missed runs %v", missed)
+	}
+
+	cjtrigger.Spec.CatchUp = "Sometimes"
+	if _, err := controller.getMissedRuns(cjtrigger, calls, since, now); err == nil {
+		t.Errorf("Expecting an error for an unknown policy")
+	}
+}
+
+func TestSyncCatchUp(t *testing.T) {
+	scheduledTimes := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheduledTimes <- r.Header.Get("Event-Scheduled-Time")
+	}))
+	defer server.Close()
+
+	lastScheduleTime := metav1.NewTime(time.Now().Add(-24 * time.Hour))
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "hourly",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:      "0 * * * *",
+			Target:        &cronjobtriggerapi.Target{URL: server.URL},
+			ExecutionMode: cronjobtriggerapi.ExecutionModeController,
+			CatchUp:       cronjobtriggerapi.CatchUpAll,
+			CatchUpLimit:  3,
+		},
+		Status: cronjobtriggerapi.CronJobTriggerStatus{LastScheduleTime: &lastScheduleTime},
+	}
+	controller, clientset, triggerClientset := newTestController(&cjtrigger)
+	controller.scheduler = scheduler.New(1)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go controller.scheduler.Run(stopCh)
+
+	sync := func() {
+		if err := controller.syncCronJobTrigger("myns/hourly"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("hourly", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		controller.cronJobInformer.GetIndexer().Update(updated)
+	}
+
+	// Replicas that are not leading leave the missed runs to the leader
+	deliveries := controller.getDeliveries()
+	controller.setDeliveries(nil)
+	sync()
+	updated, _ := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("hourly", metav1.GetOptions{})
+	if !updated.Status.LastScheduleTime.Equal(&lastScheduleTime) {
+		t.Errorf("Unexpected last schedule time %s", updated.Status.LastScheduleTime)
+	}
+
+	// The missed runs are made by the scheduler, without jobs
+	controller.setDeliveries(deliveries)
+	sync()
+	for i := 0; i < 3; i++ {
+		select {
+		case scheduledTime := <-scheduledTimes:
+			if scheduledTime == "" {
+				t.Errorf("Missing scheduled time")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Missing caught up run, received %d", i)
+		}
+	}
+	jobs, err := clientset.BatchV1().Jobs("myns").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(jobs.Items) != 0 {
+		t.Errorf("Unexpected number of jobs: %d", len(jobs.Items))
+	}
+	updated, _ = triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("hourly", metav1.GetOptions{})
+	if !lastScheduleTime.Before(updated.Status.LastScheduleTime) {
+		t.Errorf("Unexpected last schedule time %s", updated.Status.LastScheduleTime)
+	}
+
+	// The missed runs are only made once
+	sync()
+	select {
+	case scheduledTime := <-scheduledTimes:
+		t.Errorf("Unexpected run scheduled at %s", scheduledTime)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSyncCatchUpCronJob(t *testing.T) {
+	base := time.Now().UTC().Truncate(time.Hour).Add(-4 * time.Hour)
+	lastScheduleTime := metav1.NewTime(base)
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "hourly",
+			UID:       "hourly-uid",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:     "0 * * * *",
+			Target:       &cronjobtriggerapi.Target{URL: "https://example.com/hourly"},
+			CatchUp:      cronjobtriggerapi.CatchUpAll,
+			CatchUpLimit: 10,
+		},
+		Status: cronjobtriggerapi.CronJobTriggerStatus{LastScheduleTime: &lastScheduleTime},
+	}
+	name := cronjobutils.GetCronJobName(nil, &cjtrigger)
+	// The CronJob reports a later schedule than the trigger, it doesn't tell which runs of the trigger were made
+	now := metav1.Now()
+	cronJob := batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "myns",
+			Name:            name,
+			Labels:          map[string]string{"created-by": "kubeless"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "CronJobTrigger", Name: "hourly", UID: "hourly-uid"}},
+		},
+		Status: batchv1beta1.CronJobStatus{LastScheduleTime: &now},
+	}
+	// The run following the last recorded one was made by the CronJob
+	madeTime := base.Add(time.Hour)
+	madeJob := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "myns",
+			Name:            fmt.Sprintf("%s-%d", name, madeTime.Unix()/60),
+			Labels:          map[string]string{"created-by": "kubeless", cronjobutils.TriggerLabel: "hourly"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "CronJob", Name: name}},
+		},
+	}
+	controller, clientset, triggerClientset := newTestController(&cjtrigger, &cronJob, &madeJob)
+	testutil.PrependApplyReactor(clientset)
+
+	if err := controller.syncCronJobTrigger("myns/hourly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, scheduledTime := range []time.Time{base.Add(2 * time.Hour), base.Add(3 * time.Hour)} {
+		// Named after the scheduled time in seconds
+		job, err := clientset.BatchV1().Jobs("myns").Get(context.TODO(), fmt.Sprintf("%s-%d", name, scheduledTime.Unix()), metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Expecting the run scheduled at %s to be caught up: %v", scheduledTime, err)
+		}
+		if recorded, ok := cronjobutils.JobScheduledTime(job); !ok || !recorded.Equal(scheduledTime) {
+			t.Errorf("Unexpected scheduled time %s", recorded)
+		}
+	}
+	if _, err := clientset.BatchV1().Jobs("myns").Get(context.TODO(), fmt.Sprintf("%s-%d", name, madeTime.Unix()), metav1.GetOptions{}); !k8sErrors.IsNotFound(err) {
+		t.Errorf("The run made by the CronJob should not be caught up")
+	}
+	updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("hourly", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if updated.Status.LastScheduleTime.Time.Before(base.Add(3 * time.Hour)) {
+		t.Errorf("Unexpected last schedule time %s", updated.Status.LastScheduleTime)
+	}
+}
+
+func TestRecordJobRuns(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "nightly",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:      "0 0 * * *",
+			Target:        &cronjobtriggerapi.Target{URL: "https://example.com/nightly"},
+			ExecutionMode: cronjobtriggerapi.ExecutionModeController,
+		},
+	}
+	labels := map[string]string{"created-by": "kubeless", cronjobutils.TriggerLabel: "nightly"}
+	startTime := metav1.NewTime(time.Date(2018, 3, 5, 0, 0, 5, 0, time.UTC))
+	completionTime := metav1.NewTime(time.Date(2018, 3, 5, 0, 0, 15, 0, time.UTC))
+	finishedJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "trigger-nightly-25333920", Labels: labels},
+		Status: batchv1.JobStatus{
+			StartTime:      &startTime,
+			CompletionTime: &completionTime,
+			Conditions:     []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+		},
+	}
+	runningJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "trigger-nightly-manual-0123456789", Labels: labels},
+		Status:     batchv1.JobStatus{StartTime: &completionTime},
+	}
+	otherJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "trigger-other-25333920", Labels: map[string]string{"created-by": "kubeless", cronjobutils.TriggerLabel: "other"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "trigger-nightly-25333920-abcde", Labels: map[string]string{"job-name": finishedJob.Name}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				Message: `{"statusCode":200,"attempts":1,"scheduledTime":"2018-03-05T00:00:00Z","startTime":"2018-03-05T00:00:10Z","completionTime":"2018-03-05T00:00:12Z","response":"{\"rows\":42}","latency":"1.5s"}`,
+			}},
+		}}},
+	}
+
+	controller, _, triggerClientset := newTestController(&cjtrigger, finishedJob, runningJob, otherJob, pod)
+	controller.scheduler = scheduler.New(1)
+
+	// Replicas that are not leading leave the runs to the leader
+	deliveries := controller.getDeliveries()
+	controller.setDeliveries(nil)
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("nightly", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(updated.Status.Runs) != 0 {
+		t.Fatalf("Unexpected runs %+v", updated.Status.Runs)
+	}
+
+	controller.setDeliveries(deliveries)
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	updated, err = triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("nightly", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	runs := updated.Status.Runs
+	if len(runs) != 2 {
+		t.Fatalf("Unexpected runs %+v", runs)
+	}
+	// The running job has no scheduled time, it's ordered by its start time
+	if runs[0].JobName != runningJob.Name || runs[0].Outcome != cronjobtriggerapi.RunRunning {
+		t.Errorf("Unexpected run %+v", runs[0])
+	}
+	if runs[1].JobName != finishedJob.Name || runs[1].Outcome != cronjobtriggerapi.RunSucceeded || runs[1].StatusCode != 200 || runs[1].Duration.Duration != 2*time.Second {
+		t.Errorf("Unexpected run %+v", runs[1])
+	}
+	if runs[1].Response != `{"rows":42}` || runs[1].Latency == nil || runs[1].Latency.Duration != 1500*time.Millisecond {
+		t.Errorf("Unexpected run %+v", runs[1])
+	}
+
+	// The runs of the Controller execution mode are recorded as well
+	scheduledTime := time.Date(2018, 3, 6, 0, 0, 0, 0, time.UTC)
+	controller.recordResult("myns/nightly", scheduledTime, &invoker.Result{StatusCode: 500, Attempts: 3, ScheduledTime: &scheduledTime})
+	updated, _ = triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("nightly", metav1.GetOptions{})
+	if len(updated.Status.Runs) != 3 || updated.Status.Runs[0].Outcome != cronjobtriggerapi.RunFailed || updated.Status.Runs[0].Attempts != 3 {
+		t.Errorf("Unexpected runs %+v", updated.Status.Runs)
+	}
+}
+
+func TestRecordJobRunsWatermark(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "nightly",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:      "0 0 * * *",
+			Target:        &cronjobtriggerapi.Target{URL: "https://example.com/nightly"},
+			ExecutionMode: cronjobtriggerapi.ExecutionModeController,
+		},
+	}
+	labels := map[string]string{"created-by": "kubeless", cronjobutils.TriggerLabel: "nightly"}
+	objects := []runtime.Object{&cjtrigger}
+	// More finished jobs than the runs kept in the status
+	for i := 0; i < maxRuns+2; i++ {
+		scheduledTime := time.Date(2018, 3, 1+i, 0, 0, 0, 0, time.UTC)
+		completionTime := metav1.NewTime(scheduledTime.Add(10 * time.Second))
+		objects = append(objects, &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "myns",
+				Name:        fmt.Sprintf("trigger-nightly-%d", scheduledTime.Unix()),
+				Labels:      labels,
+				Annotations: map[string]string{cronjobutils.ScheduledTimeAnnotation: scheduledTime.Format(time.RFC3339)},
+			},
+			Status: batchv1.JobStatus{
+				StartTime:  &metav1.Time{Time: scheduledTime},
+				Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, LastTransitionTime: completionTime}},
+			},
+		})
+	}
+	controller, _, triggerClientset := newTestController(objects...)
+	controller.scheduler = scheduler.New(1)
+	recorder := record.NewFakeRecorder(2 * maxRuns)
+	controller.recorder = recorder
+
+	sync := func() *cronjobtriggerapi.CronJobTrigger {
+		if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("nightly", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		controller.cronJobInformer.GetIndexer().Update(updated)
+		return updated
+	}
+
+	first := sync()
+	if len(first.Status.Runs) != maxRuns || first.Status.LastCompletionTime == nil {
+		t.Fatalf("Unexpected status %+v", first.Status)
+	}
+	if len(recorder.Events) != maxRuns+2 {
+		t.Errorf("Unexpected number of events: %d", len(recorder.Events))
+	}
+	for len(recorder.Events) > 0 {
+		<-recorder.Events
+	}
+
+	// The jobs dropped from the runs are not recorded again
+	triggerClientset.ClearActions()
+	second := sync()
+	if !reflect.DeepEqual(first.Status, second.Status) {
+		t.Errorf("Unexpected status change from %+v to %+v", first.Status, second.Status)
+	}
+	for _, action := range triggerClientset.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("Unexpected update of the trigger: %v", action)
+		}
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("Unexpected event %q", <-recorder.Events)
+	}
+}
+
+func TestSyncEvents(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "nightly",
+			UID:       "nightly-uid",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule: "0 0 * * *",
+			Target:   &cronjobtriggerapi.Target{URL: "https://example.com/nightly"},
+		},
+	}
+	// Left behind by a previous target of the trigger
+	staleCronJob := batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "myns",
+			Name:            "trigger-nightly-old",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "CronJobTrigger", Name: "nightly", UID: "nightly-uid"}},
+		},
+	}
+	name := cronjobutils.GetCronJobName(nil, &cjtrigger)
+	controller, clientset, _ := newTestController(&cjtrigger, &staleCronJob)
+	testutil.PrependApplyReactor(clientset)
+	recorder := record.NewFakeRecorder(10)
+	controller.recorder = recorder
+
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvent(t, recorder, "Normal CronJobCreated Created CronJob calling https://example.com/nightly")
+	expectEvent(t, recorder, "Normal CronJobDeleted Deleted CronJob trigger-nightly-old")
+
+	// The trigger can't take over a cron job it didn't create
+	clientset.BatchV1beta1().CronJobs("myns").Delete(context.TODO(), name, metav1.DeleteOptions{})
+	clientset.BatchV1beta1().CronJobs("myns").Create(context.TODO(), &batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: name},
+	}, metav1.CreateOptions{})
+	if err := controller.syncCronJobTrigger("myns/nightly"); !cronjobutils.IsConflict(err) {
+		t.Fatalf("Expecting a conflict, got %v", err)
+	}
+	expectEvent(t, recorder, "Warning ConflictingCronJob Found a conflicting cronjob object myns/nightly. Aborting")
+
+	scheduledTime := time.Date(2018, 3, 6, 0, 0, 0, 0, time.UTC)
+	controller.recordResult("myns/nightly", scheduledTime, &invoker.Result{StatusCode: 500, Attempts: 3, ScheduledTime: &scheduledTime})
+	expectEvent(t, recorder, "Warning InvocationFailed Run scheduled at 2018-03-06T00:00:00Z failed: Received the HTTP status 500")
+	busyTime := time.Date(2018, 3, 7, 0, 0, 0, 0, time.UTC)
+	controller.recordResult("myns/nightly", busyTime, &invoker.Result{Skipped: scheduler.SkippedBusy, ScheduledTime: &busyTime})
+	expectEvent(t, recorder, "Warning RunSkipped Run scheduled at 2018-03-07T00:00:00Z skipped: "+scheduler.SkippedBusy)
+
+	clientset.BatchV1beta1().CronJobs("myns").Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvent(t, recorder, "Normal CronJobCreated Created CronJob calling https://example.com/nightly")
+
+	// Switching to the Controller execution mode removes the cron jobs of the trigger
+	cjtrigger.Spec.ExecutionMode = cronjobtriggerapi.ExecutionModeController
+	controller.cronJobInformer.GetIndexer().Update(&cjtrigger)
+	controller.scheduler = scheduler.New(1)
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvent(t, recorder, "Normal CronJobDeleted Deleted CronJob "+name)
+}
+
+func TestCronJobTriggerDeleted(t *testing.T) {
+	now := metav1.Now()
+	function := kubelessApi.Function{ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "cleanup"}}
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "myns",
+			Name:              "nightly",
+			UID:               "nightly-uid",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{cronJobTriggerFinalizer},
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:     "0 0 * * *",
+			FunctionName: "cleanup",
+		},
+	}
+	// Named after the function called by the trigger
+	cronJob := batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "myns",
+			Name:            cronjobutils.GetCronJobName(&function, &cjtrigger),
+			OwnerReferences: []metav1.OwnerReference{{Kind: "CronJobTrigger", Name: "nightly", UID: "nightly-uid"}},
+		},
+	}
+	// Named after the trigger but owned by another one
+	otherCronJob := batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "myns",
+			Name:            "trigger-nightly",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "CronJobTrigger", Name: "nightly", UID: "previous-uid"}},
+		},
+	}
+	controller, clientset, _ := newTestController(&cjtrigger, &function, &cronJob, &otherCronJob)
+	recorder := record.NewFakeRecorder(10)
+	controller.recorder = recorder
+
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvent(t, recorder, "Normal CronJobDeleted Deleted CronJob "+cronJob.ObjectMeta.Name)
+	cronJobs, err := clientset.BatchV1beta1().CronJobs("myns").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cronJobs.Items) != 1 || cronJobs.Items[0].ObjectMeta.Name != "trigger-nightly" {
+		t.Errorf("Unexpected cron jobs: %v", cronJobs.Items)
+	}
+
+	// Nothing left to remove
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("Unexpected event %q", event)
+	default:
+	}
+}
+
+func TestCronJobDrift(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "nightly",
+			UID:       "nightly-uid",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule: "0 0 * * *",
+			Target:   &cronjobtriggerapi.Target{URL: "https://example.com/nightly"},
+		},
+	}
+	name := cronjobutils.GetCronJobName(nil, &cjtrigger)
+	controller, clientset, _ := newTestController(&cjtrigger)
+	testutil.PrependApplyReactor(clientset)
+	recorder := record.NewFakeRecorder(10)
+	controller.recorder = recorder
+	queue := controller.queue
+
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvent(t, recorder, "Normal CronJobCreated Created CronJob calling https://example.com/nightly")
+	cronJob, err := clientset.BatchV1beta1().CronJobs("myns").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A change of the status doesn't need to be reverted
+	scheduled := cronJob.DeepCopy()
+	scheduled.Status.LastScheduleTime = &metav1.Time{Time: time.Date(2018, 3, 6, 0, 0, 0, 0, time.UTC)}
+	if cronJobChanged(cronJob, scheduled) {
+		t.Error("Expecting a change of the status to be ignored")
+	}
+
+	// The cron job is recreated if deleted
+	clientset.BatchV1beta1().CronJobs("myns").Delete(context.TODO(), name, metav1.DeleteOptions{})
+	controller.enqueueCronJobOwner(cache.DeletedFinalStateUnknown{Key: "myns/" + name, Obj: cronJob})
+	if queue.Len() != 1 {
+		t.Fatalf("Expecting the trigger to be enqueued")
+	}
+	if key, _ := queue.Get(); key != "myns/nightly" {
+		t.Errorf("Unexpected key %v", key)
+	}
+	queue.Done("myns/nightly")
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvent(t, recorder, "Warning CronJobDrift Recreated the CronJob calling https://example.com/nightly, deleted outside of the trigger")
+	if controller.hasDrifted("myns/nightly") {
+		t.Error("Expecting the drift to be cleared after the sync")
+	}
+
+	// The spec of the cron job is restored if modified
+	cronJob, err = clientset.BatchV1beta1().CronJobs("myns").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	modified := cronJob.DeepCopy()
+	modified.Spec.Schedule = "*/5 * * * *"
+	clientset.BatchV1beta1().CronJobs("myns").Update(context.TODO(), modified, metav1.UpdateOptions{})
+	if !cronJobChanged(cronJob, modified) {
+		t.Fatal("Expecting a change of the schedule to be detected")
+	}
+	controller.enqueueCronJobOwner(modified)
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvent(t, recorder, "Warning CronJobDrift Restored the CronJob calling https://example.com/nightly, modified outside of the trigger")
+	restored, err := clientset.BatchV1beta1().CronJobs("myns").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if restored.Spec.Schedule != "0 0 * * *" {
+		t.Errorf("Expecting the schedule to be restored, got %q", restored.Spec.Schedule)
+	}
+}
+
+func TestCronJobSelfUpdate(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "nightly",
+			UID:       "nightly-uid",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule: "0 0 * * *",
+			Target:   &cronjobtriggerapi.Target{URL: "https://example.com/nightly"},
+		},
+	}
+	name := cronjobutils.GetCronJobName(nil, &cjtrigger)
+	controller, clientset, triggerClient := newTestController(&cjtrigger)
+	testutil.PrependApplyReactor(clientset)
+	recorder := record.NewFakeRecorder(10)
+	controller.recorder = recorder
+
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvent(t, recorder, "Normal CronJobCreated Created CronJob calling https://example.com/nightly")
+	created, err := clientset.BatchV1beta1().CronJobs("myns").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The update of the cron job following a change of the trigger is not a drift
+	updatedTrigger, err := triggerClient.KubelessV1beta1().CronJobTriggers("myns").Get("nightly", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	updatedTrigger.Spec.Schedule = "0 1 * * *"
+	controller.cronJobInformer.GetIndexer().Update(updatedTrigger)
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvent(t, recorder, "Normal CronJobUpdated Updated CronJob calling https://example.com/nightly")
+	updated, err := clientset.BatchV1beta1().CronJobs("myns").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	controller.cronJobUpdated(created, updated)
+	if controller.hasDrifted("myns/nightly") || controller.queue.Len() != 0 {
+		t.Fatal("Expecting the update made by the controller not to be reported as a drift")
+	}
+
+	// Another change of the trigger is still reported as a regular update
+	updatedTrigger = updatedTrigger.DeepCopy()
+	updatedTrigger.Spec.Schedule = "0 2 * * *"
+	controller.cronJobInformer.GetIndexer().Update(updatedTrigger)
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvent(t, recorder, "Normal CronJobUpdated Updated CronJob calling https://example.com/nightly")
+
+	// A change made outside of the controller is a drift
+	modified, err := clientset.BatchV1beta1().CronJobs("myns").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	modified = modified.DeepCopy()
+	modified.Spec.Schedule = "*/5 * * * *"
+	modified.ObjectMeta.ResourceVersion = "external"
+	controller.cronJobUpdated(updated, modified)
+	if !controller.hasDrifted("myns/nightly") {
+		t.Error("Expecting an external change to be reported as a drift")
+	}
+}
+
+func TestOwnerReference(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "nightly",
+			UID:       "nightly-uid",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule: "0 0 * * *",
+			Target:   &cronjobtriggerapi.Target{URL: "https://example.com/nightly"},
+		},
+	}
+	name := cronjobutils.GetCronJobName(nil, &cjtrigger)
+	// Created by a previous version of the controller, with an owner reference ignored by the garbage collector
+	legacyCronJob := batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      name,
+			Labels:    map[string]string{"created-by": "kubeless"},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "kubeless.io/v1beta1", Kind: "Trigger", Name: "nightly", UID: "nightly-uid"},
+			},
+		},
+	}
+	controller, clientset, _ := newTestController(&cjtrigger, &legacyCronJob)
+	testutil.PrependApplyReactor(clientset)
+	queue := controller.queue
+
+	// Changes of the legacy cron jobs still reach their trigger
+	controller.enqueueCronJobOwner(&legacyCronJob)
+	if queue.Len() != 1 {
+		t.Fatalf("Expecting the owner of the legacy cron job to be enqueued")
+	}
+
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cronJob, err := clientset.BatchV1beta1().CronJobs("myns").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The garbage collector looks up the owner through its API version and kind, which must be the registered ones
+	gvks, _, err := triggerscheme.Scheme.ObjectKinds(&cronjobtriggerapi.CronJobTrigger{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	apiVersion, kind := gvks[0].ToAPIVersionAndKind()
+	expected := []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: "nightly", UID: "nightly-uid"}}
+	if !reflect.DeepEqual(cronJob.ObjectMeta.OwnerReferences, expected) {
+		t.Errorf("Unexpected owner references %v, expecting %v", cronJob.ObjectMeta.OwnerReferences, expected)
+	}
+}
+
+func TestSweepCronJobs(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "nightly",
+			UID:       "nightly-uid",
+		},
+	}
+	created := metav1.NewTime(time.Now().Add(-time.Hour))
+	newCronJob := func(name string, owners []metav1.OwnerReference, jobLabels map[string]string) *batchv1beta1.CronJob {
+		return &batchv1beta1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:         "myns",
+				Name:              name,
+				Labels:            map[string]string{"created-by": "kubeless"},
+				OwnerReferences:   owners,
+				CreationTimestamp: created,
+			},
+			Spec: batchv1beta1.CronJobSpec{
+				JobTemplate: batchv1beta1.JobTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: jobLabels}},
+			},
+		}
+	}
+	cronJobs := []*batchv1beta1.CronJob{
+		newCronJob("trigger-nightly", []metav1.OwnerReference{{APIVersion: "kubeless.io/v1beta1", Kind: "CronJobTrigger", Name: "nightly", UID: "nightly-uid"}}, nil),
+		// Created for a previous trigger with the same name, and owned by another object as well
+		newCronJob("trigger-nightly-old", []metav1.OwnerReference{
+			{APIVersion: "v1", Kind: "ConfigMap", Name: "schedules", UID: "schedules-uid"},
+			{APIVersion: "kubeless.io/v1beta1", Kind: "CronJobTrigger", Name: "nightly", UID: "previous-uid"},
+		}, nil),
+		newCronJob("trigger-hourly", []metav1.OwnerReference{{APIVersion: "kubeless.io/v1beta1", Kind: "Trigger", Name: "hourly", UID: "hourly-uid"}}, nil),
+		// Lost its owner reference, but its jobs still tell its trigger
+		newCronJob("trigger-weekly", nil, map[string]string{cronjobutils.TriggerLabel: "weekly"}),
+		// Not created by a trigger
+		newCronJob("backup", nil, nil),
+	}
+	// Its trigger may not be cached yet
+	recent := newCronJob("trigger-monthly", []metav1.OwnerReference{{APIVersion: "kubeless.io/v1beta1", Kind: "CronJobTrigger", Name: "monthly", UID: "monthly-uid"}}, nil)
+	recent.ObjectMeta.CreationTimestamp = metav1.Now()
+	cronJobs = append(cronJobs, recent)
+
+	objects := []runtime.Object{&cjtrigger}
+	for _, cronJob := range cronJobs {
+		objects = append(objects, cronJob)
+	}
+	controller, clientset, _ := newTestController(objects...)
+	recorder := record.NewFakeRecorder(10)
+	controller.recorder = recorder
+	controller.orphanSweepDryRun = true
+	queue := controller.queue
+
+	listCronJobs := func() []string {
+		list, err := clientset.BatchV1beta1().CronJobs("myns").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		names := []string{}
+		for _, cronJob := range list.Items {
+			names = append(names, cronJob.ObjectMeta.Name)
+		}
+		return names
+	}
+
+	// The orphaned cron jobs are only reported in the dry-run mode
+	controller.sweepCronJobs()
+	if names := listCronJobs(); len(names) != len(cronJobs) {
+		t.Errorf("Unexpected cron jobs %v", names)
+	}
+	events := map[string]bool{}
+	for len(recorder.Events) > 0 {
+		events[<-recorder.Events] = true
+	}
+	expectedEvents := map[string]bool{
+		"Normal CronJobAdopted Adopted CronJob trigger-nightly-old":                                                               true,
+		"Warning OrphanedCronJob The trigger myns/hourly no longer exists, the CronJob would be deleted without the dry-run mode": true,
+		"Warning OrphanedCronJob The trigger myns/weekly no longer exists, the CronJob would be deleted without the dry-run mode": true,
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("Unexpected events %v, expecting %v", events, expectedEvents)
+	}
+	if queue.Len() != 1 {
+		t.Errorf("Expecting the adopting trigger to be enqueued")
+	}
+	adopted, err := clientset.BatchV1beta1().CronJobs("myns").Get(context.TODO(), "trigger-nightly-old", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	owners := adopted.ObjectMeta.OwnerReferences
+	if len(owners) != 2 || owners[0].UID != "schedules-uid" || owners[1].UID != "nightly-uid" {
+		t.Errorf("Unexpected owner references %v", adopted.ObjectMeta.OwnerReferences)
+	}
+
+	controller.orphanSweepDryRun = false
+	controller.sweepCronJobs()
+	expected := []string{"backup", "trigger-monthly", "trigger-nightly", "trigger-nightly-old"}
+	if names := listCronJobs(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Unexpected cron jobs %v, expecting %v", names, expected)
+	}
+}
+
+func TestResolveJobTemplate(t *testing.T) {
+	cjtrigger := &cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "nightly"},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			JobTemplate: &cronjobtriggerapi.JobTemplate{PriorityClassName: "high"},
+		},
+	}
+	controller := CronJobTriggerController{}
+	if resolved := controller.resolveJobTemplate(cjtrigger); resolved != cjtrigger {
+		t.Errorf("Expecting the trigger to be unchanged without default template")
+	}
+
+	controller.jobTemplate = &cronjobtriggerapi.JobTemplate{ServiceAccountName: "invoker", PriorityClassName: "low"}
+	resolved := controller.resolveJobTemplate(cjtrigger)
+	expected := &cronjobtriggerapi.JobTemplate{ServiceAccountName: "invoker", PriorityClassName: "high"}
+	if !reflect.DeepEqual(resolved.Spec.JobTemplate, expected) {
+		t.Errorf("Unexpected job template %+v expecting %+v", resolved.Spec.JobTemplate, expected)
+	}
+	if cjtrigger.Spec.JobTemplate.ServiceAccountName != "" {
+		t.Errorf("The cached trigger should not be modified")
+	}
+}
+
+// expectEvent checks that the next event emitted is the given one
+func expectEvent(t *testing.T, recorder *record.FakeRecorder, expected string) {
+	t.Helper()
+	select {
+	case event := <-recorder.Events:
+		if event != expected {
+			t.Errorf("Unexpected event %q, expecting %q", event, expected)
+		}
+	default:
+		t.Errorf("Missing event %q", expected)
+	}
+}
+
+func TestAlerting(t *testing.T) {
+	notifications := make(chan alertNotification, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification alertNotification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			t.Errorf("Unable to decode the notification: %v", err)
+		}
+		notifications <- notification
+	}))
+	defer server.Close()
+
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "nightly",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:      "0 0 * * *",
+			Target:        &cronjobtriggerapi.Target{URL: "https://example.com/nightly"},
+			ExecutionMode: cronjobtriggerapi.ExecutionModeController,
+			Alerting: &cronjobtriggerapi.AlertingPolicy{
+				FailureThreshold: 2,
+				Target:           cronjobtriggerapi.Target{URL: server.URL},
+			},
+		},
+	}
+	controller, _, triggerClientset := newTestController(&cjtrigger)
+	controller.scheduler = scheduler.New(1)
+	defer runDeliveries(controller)()
+	expectHealth := func(status metav1.ConditionStatus, failures int32) {
+		t.Helper()
+		updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("nightly", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		condition := meta.FindStatusCondition(updated.Status.Conditions, cronjobtriggerapi.Healthy)
+		if condition == nil || condition.Status != status || updated.Status.ConsecutiveFailures != failures {
+			t.Errorf("Unexpected health %+v after %d failure(s)", condition, updated.Status.ConsecutiveFailures)
+		}
+	}
+	recordRun := func(day int, statusCode int) {
+		scheduledTime := time.Date(2018, 3, day, 0, 0, 0, 0, time.UTC)
+		controller.recordResult("myns/nightly", scheduledTime, &invoker.Result{StatusCode: statusCode, Attempts: 1, ScheduledTime: &scheduledTime})
+		updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("nightly", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		controller.cronJobInformer.GetIndexer().Update(updated)
+	}
+
+	if err := controller.syncCronJobTrigger("myns/nightly"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectHealth(metav1.ConditionTrue, 0)
+
+	recordRun(5, 500)
+	expectHealth(metav1.ConditionTrue, 1)
+	recordRun(6, 500)
+	expectHealth(metav1.ConditionFalse, 2)
+	recordRun(7, 500)
+	expectHealth(metav1.ConditionFalse, 3)
+	recordRun(8, 200)
+	expectHealth(metav1.ConditionTrue, 0)
+
+	// A single notification is sent when the trigger becomes unhealthy, and another one when it recovers
+	var states []string
+	for len(states) < 2 {
+		select {
+		case notification := <-notifications:
+			states = append(states, notification.State)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Missing notification, received %v", states)
+		}
+	}
+	if states[0] != cronjobtriggerapi.AlertFailing || states[1] != cronjobtriggerapi.AlertRecovered {
+		t.Errorf("Unexpected notifications %v", states)
+	}
+
+	// Lowering the threshold below the current failures doesn't cross it with a new run
+	recordRun(9, 500)
+	expectHealth(metav1.ConditionTrue, 1)
+	updated, _ := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("nightly", metav1.GetOptions{})
+	updated.Spec.Alerting.FailureThreshold = 1
+	controller.cronJobInformer.GetIndexer().Update(updated)
+	if err := controller.syncHealth(updated); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectHealth(metav1.ConditionFalse, 1)
+	recordRun(10, 500)
+	expectHealth(metav1.ConditionFalse, 2)
+	// Replicas that are not leading don't send the notifications
+	controller.setDeliveries(nil)
+	recordRun(11, 200)
+	expectHealth(metav1.ConditionTrue, 0)
+	select {
+	case notification := <-notifications:
+		t.Errorf("Unexpected notification %+v", notification)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	type received struct {
+		letter  deadLetter
+		eventID string
+	}
+	letters := make(chan received, 10)
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var letter deadLetter
+		if err := json.NewDecoder(r.Body).Decode(&letter); err != nil {
+			t.Errorf("Unable to decode the dead letter: %v", err)
+		}
+		if calls == 1 {
+			// The first attempt fails, the dead letter is sent again
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		letters <- received{letter: letter, eventID: r.Header.Get("Event-Id")}
+	}))
+	defer server.Close()
+
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "nightly",
+			UID:       "nightly-uid",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:      "0 0 * * *",
+			Target:        &cronjobtriggerapi.Target{URL: "https://example.com/nightly"},
+			Payload:       map[string]interface{}{"report": "sales"},
+			ExecutionMode: cronjobtriggerapi.ExecutionModeController,
+			DeadLetter:    &cronjobtriggerapi.Target{URL: server.URL},
+		},
+	}
+	controller, _, triggerClientset := newTestController(&cjtrigger)
+	recorder := record.NewFakeRecorder(10)
+	controller.recorder = recorder
+	defer runDeliveries(controller)()
+	recordResult := func(result *invoker.Result) {
+		controller.recordResult("myns/nightly", *result.ScheduledTime, result)
+		updated, err := triggerClientset.KubelessV1beta1().CronJobTriggers("myns").Get("nightly", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		controller.cronJobInformer.GetIndexer().Update(updated)
+	}
+
+	// Successful and skipped runs are not sent
+	scheduledTime := time.Date(2018, 3, 5, 0, 0, 0, 0, time.UTC)
+	recordResult(&invoker.Result{StatusCode: 200, Attempts: 1, ScheduledTime: &scheduledTime})
+	recordResult(&invoker.Result{Skipped: "Maintenance", ScheduledTime: &scheduledTime})
+	recordResult(&invoker.Result{StatusCode: 503, Attempts: 3, ScheduledTime: &scheduledTime})
+	expectEvent(t, recorder, "Warning InvocationFailed Run scheduled at 2018-03-05T00:00:00Z failed: Received the HTTP status 503")
+	// A run recorded already is not sent again
+	recordResult(&invoker.Result{StatusCode: 503, Attempts: 3, ScheduledTime: &scheduledTime})
+
+	var attempts []received
+	for len(attempts) < 2 {
+		select {
+		case attempt := <-letters:
+			attempts = append(attempts, attempt)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Missing dead letter, received %+v", attempts)
+		}
+	}
+	if attempts[0].eventID == "" || attempts[0].eventID != attempts[1].eventID {
+		t.Errorf("Expecting the attempts to share their event ID, got %q and %q", attempts[0].eventID, attempts[1].eventID)
+	}
+	letter := attempts[1].letter
+	payload, ok := letter.Payload.(map[string]interface{})
+	if !ok || payload["report"] != "sales" {
+		t.Errorf("Unexpected payload %v", letter.Payload)
+	}
+	if letter.Trigger != "nightly" || letter.StatusCode != 503 || letter.Attempts != 3 || letter.ScheduledTime == nil || !letter.ScheduledTime.Time.Equal(scheduledTime) {
+		t.Errorf("Unexpected dead letter %+v", letter)
+	}
+	waitEvent(t, recorder, "Normal DeadLetterSent Sent the failed run to the dead-letter target at "+server.URL)
+	select {
+	case attempt := <-letters:
+		t.Errorf("Unexpected dead letter %+v", attempt)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLead(t *testing.T) {
+	cjtrigger := cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "nightly"},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule:   "0 0 * * *",
+			Target:     &cronjobtriggerapi.Target{URL: "https://example.com/nightly"},
+			DeadLetter: &cronjobtriggerapi.Target{URL: "https://example.com/failures"},
+		},
+	}
+	controller, _, _ := newTestController(&cjtrigger)
+	controller.setDeliveries(nil)
+	// Replicas that are not leading give up the dead letters
+	controller.sendDeadLetter(&cjtrigger, cronjobtriggerapi.TriggerRun{Outcome: cronjobtriggerapi.RunFailed})
+
+	stopCh := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		controller.Lead(stopCh)
+		close(stopped)
+	}()
+	if err := wait.PollImmediate(time.Millisecond, 5*time.Second, func() (bool, error) { return controller.isLeading(), nil }); err != nil {
+		t.Fatalf("Expecting the replica to lead")
+	}
+	// The triggers are synced again to record the runs of the jobs that finished before
+	if controller.queue.Len() != 1 {
+		t.Errorf("Expecting the trigger to be queued, got %d keys", controller.queue.Len())
+	}
+	deliveries := controller.getDeliveries()
+
+	close(stopCh)
+	<-stopped
+	if controller.isLeading() || !deliveries.ShuttingDown() {
+		t.Errorf("Expecting the deliveries to stop with the leadership")
+	}
+}
+
+// runDeliveries sends the queued alerting notifications and dead letters until the returned function is called
+func runDeliveries(controller *CronJobTriggerController) func() {
+	go controller.runDeliveryWorker(controller.deliveries)
+	return controller.deliveries.ShutDown
+}
+
+// waitEvent expects the next event of the recorder, emitted asynchronously
+func waitEvent(t *testing.T, recorder *record.FakeRecorder, expected string) {
+	t.Helper()
+	select {
+	case event := <-recorder.Events:
+		if event != expected {
+			t.Errorf("Unexpected event %q, expecting %q", event, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Missing event %q", expected)
+	}
+}
+
+func TestEnqueueServiceTriggers(t *testing.T) {
+	triggers := []runtime.Object{
+		&cronjobtriggerapi.CronJobTrigger{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "nightly"},
+			Spec:       cronjobtriggerapi.CronJobTriggerSpec{FunctionName: "report"},
+		},
+		&cronjobtriggerapi.CronJobTrigger{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "hourly"},
+			Spec: cronjobtriggerapi.CronJobTriggerSpec{
+				Target:     &cronjobtriggerapi.Target{URL: "https://example.com/hourly"},
+				DeadLetter: &cronjobtriggerapi.Target{Service: &cronjobtriggerapi.ServiceTarget{Name: "failures"}},
+			},
+		},
+		&cronjobtriggerapi.CronJobTrigger{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "nightly"},
+			Spec:       cronjobtriggerapi.CronJobTriggerSpec{FunctionName: "report"},
+		},
+	}
+	controller, _, _ := newTestController(triggers...)
+
+	for _, svc := range []string{"report", "failures", "unused"} {
+		controller.enqueueServiceTriggers(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: svc}})
+	}
+	if controller.queue.Len() != 2 {
+		t.Errorf("Unexpected number of queued triggers: %d", controller.queue.Len())
+	}
+	for _, expected := range []string{"myns/nightly", "myns/hourly"} {
+		if key, _ := controller.queue.Get(); key != expected {
+			t.Errorf("Unexpected key %v, expecting %s", key, expected)
+		}
+	}
+}
+
+func TestResolveFollowUps(t *testing.T) {
+	cjtrigger := &cronjobtriggerapi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "myns",
+			Name:      "etl",
+		},
+		Spec: cronjobtriggerapi.CronJobTriggerSpec{
+			Schedule: "0 0 * * *",
+			Target:   &cronjobtriggerapi.Target{Function: &cronjobtriggerapi.FunctionTarget{Name: "extract"}},
+			OnSuccess: []cronjobtriggerapi.FollowUp{
+				{Target: cronjobtriggerapi.Target{Function: &cronjobtriggerapi.FunctionTarget{Name: "transform"}}, PassResponse: true},
+				{Target: cronjobtriggerapi.Target{URL: "https://example.com/done"}},
+			},
+		},
+	}
+	controller, _, _ := newTestController(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "transform"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http-function-port", Port: 8080}}},
+	})
+
+	resolved, err := controller.resolveFollowUps(cjtrigger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	request, err := cronjobutils.GetInvocationRequest(nil, resolved, "http://extract.myns.svc.cluster.local:8080")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []invoker.FollowUp{
+		{URL: "http://transform.myns.svc.cluster.local:8080", PassResponse: true},
+		{URL: "https://example.com/done"},
+	}
+	if !reflect.DeepEqual(request.OnSuccess, expected) {
+		t.Errorf("Unexpected follow-ups %+v", request.OnSuccess)
+	}
+	if cjtrigger.Spec.OnSuccess[0].Target.Function == nil {
+		t.Errorf("The trigger should not be modified")
+	}
+
+	// Follow-ups calling missing functions can't be resolved
+	cjtrigger.Spec.OnFailure = []cronjobtriggerapi.FollowUp{{Target: cronjobtriggerapi.Target{Function: &cronjobtriggerapi.FunctionTarget{Name: "cleanup"}}}}
+	if _, err := controller.resolveFollowUps(cjtrigger); err == nil {
+		t.Errorf("Expecting an error for a missing function")
+	}
+}
+
+// newTestController returns a controller backed by fake clientsets holding the given objects, which are also added to
+// the stores of the matching informers, along with the fake clientsets
+func newTestController(objects ...runtime.Object) (*CronJobTriggerController, *fake.Clientset, *cronjobTriggerFake.Clientset) {
+	controller := &CronJobTriggerController{
+		cronJobInformer:     cache.NewSharedIndexInformer(&cache.ListWatch{}, &cronjobtriggerapi.CronJobTrigger{}, 0, cache.Indexers{serviceIndex: serviceTriggerIndexFunc, selectorIndex: selectorTriggerIndexFunc}),
+		functionInformer:    cache.NewSharedIndexInformer(&cache.ListWatch{}, &kubelessApi.Function{}, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+		calendarInformer:    cache.NewSharedIndexInformer(&cache.ListWatch{}, &cronjobtriggerapi.TriggerCalendar{}, 0, cache.Indexers{}),
+		jobInformer:         cache.NewSharedIndexInformer(&cache.ListWatch{}, &batchv1.Job{}, 0, cache.Indexers{triggerIndex: jobTriggerIndexFunc}),
+		ownedCronJobs:       cache.NewSharedIndexInformer(&cache.ListWatch{}, &batchv1beta1.CronJob{}, 0, cache.Indexers{}),
+		serviceInformer:     cache.NewSharedIndexInformer(&cache.ListWatch{}, &corev1.Service{}, 0, cache.Indexers{}),
+		queue:               workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		deliveries:          workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond)),
+		deliveryRateLimiter: workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond),
+		clusterDomain:       "cluster.local",
+		logger:              logrus.WithField("controller", "cronjob-trigger-controller"),
+	}
+	var kubeObjects, triggerObjects []runtime.Object
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *cronjobtriggerapi.CronJobTrigger:
+			triggerObjects = append(triggerObjects, o)
+			controller.cronJobInformer.GetIndexer().Add(o)
+		case *cronjobtriggerapi.TriggerCalendar:
+			triggerObjects = append(triggerObjects, o)
+			controller.calendarInformer.GetIndexer().Add(o)
+		case *kubelessApi.Function:
+			controller.functionInformer.GetIndexer().Add(o)
+		case *batchv1.Job:
+			kubeObjects = append(kubeObjects, o)
+			controller.jobInformer.GetIndexer().Add(o)
+		case *batchv1beta1.CronJob:
+			kubeObjects = append(kubeObjects, o)
+			controller.ownedCronJobs.GetIndexer().Add(o)
+		case *corev1.Service:
+			kubeObjects = append(kubeObjects, o)
+			controller.serviceInformer.GetIndexer().Add(o)
+		default:
+			kubeObjects = append(kubeObjects, o)
+		}
+	}
+	clientset := fake.NewSimpleClientset(kubeObjects...)
+	triggerClientset := cronjobTriggerFake.NewSimpleClientset(triggerObjects...)
+	controller.clientset = clientset
+	controller.cronjobclient = triggerClientset
+	return controller, clientset, triggerClientset
+}
@@ -0,0 +1,536 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package invoker
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	cronjobTriggerApi "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RequestEnvVar is the environment variable holding the serialized Request of an invocation Job
+	RequestEnvVar = "INVOCATION_REQUEST"
+	// TerminationMessagePath is the file the invoker writes its serialized Result to, for the controller to read it
+	TerminationMessagePath = "/dev/termination-log"
+
+	eventNamespace  = "cronjobtrigger.kubeless.io"
+	eventTimeFormat = "2006-01-02 15:04:05-07:00"
+
+	defaultContentType    = "application/json"
+	defaultMaxAttempts    = 1
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 30 * time.Second
+
+	// Size of the beginning of the response body kept in the Result, small enough for the termination message of the Job
+	maxResponseBytes = 512
+	// Size of the response body passed to a follow-up call
+	maxPassedResponseBytes = 1 << 20
+	// Size limit of the termination message of a container
+	maxTerminationMessageBytes = 4096
+	// Size of the errors and URLs kept when the Result doesn't fit in the termination message
+	maxTruncatedBytes = 256
+)
+
+// Status codes retried when the retry policy doesn't list any
+var defaultRetryableStatusCodes = []int32{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Request describes a call to a function on behalf of a CronJob trigger
+type Request struct {
+	URL            string                         `json:"url"`
+	Payload        string                         `json:"payload,omitempty"`
+	ContentType    string                         `json:"contentType,omitempty"`
+	EventID        string                         `json:"eventId,omitempty"`
+	TriggerUID     string                         `json:"triggerUID,omitempty"`
+	ScheduledTime  *time.Time                     `json:"scheduledTime,omitempty"`
+	TimeoutSeconds int                            `json:"timeoutSeconds,omitempty"`
+	Retry          *cronjobTriggerApi.RetryPolicy `json:"retry,omitempty"`
+	TLS            *TLSConfig                     `json:"tls,omitempty"`
+	Jitter         *metav1.Duration               `json:"jitter,omitempty"`
+	Exclusions     []Exclusion                    `json:"exclusions,omitempty"`
+	OnSuccess      []FollowUp                     `json:"onSuccess,omitempty"`
+	OnFailure      []FollowUp                     `json:"onFailure,omitempty"`
+}
+
+// FollowUp is a call made after the call of a Request completes
+type FollowUp struct {
+	URL          string `json:"url"`
+	PassResponse bool   `json:"passResponse,omitempty"`
+}
+
+// Exclusion is a time range during which scheduled calls are skipped
+type Exclusion struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// TLSConfig points to the certificates used to call a function over HTTPS
+type TLSConfig struct {
+	CAFile     string `json:"caFile,omitempty"`
+	CertFile   string `json:"certFile,omitempty"`
+	KeyFile    string `json:"keyFile,omitempty"`
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// Result is the outcome of a Request
+type Result struct {
+	StatusCode     int              `json:"statusCode,omitempty"`
+	Attempts       int32            `json:"attempts"`
+	Error          string           `json:"error,omitempty"`
+	Skipped        string           `json:"skipped,omitempty"`
+	ScheduledTime  *time.Time       `json:"scheduledTime,omitempty"`
+	StartTime      *time.Time       `json:"startTime,omitempty"`
+	CompletionTime *time.Time       `json:"completionTime,omitempty"`
+	Response       string           `json:"response,omitempty"`
+	Latency        *metav1.Duration `json:"latency,omitempty"`
+	FollowUps      []FollowUpResult `json:"followUps,omitempty"`
+}
+
+// FollowUpResult is the outcome of a follow-up call
+type FollowUpResult struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Attempts   int32  `json:"attempts"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Succeeded returns true if the last attempt received a successful response, as well as every follow-up call
+func (r *Result) Succeeded() bool {
+	if !succeeded(r.StatusCode, r.Error) {
+		return false
+	}
+	for _, followUp := range r.FollowUps {
+		if !followUp.Succeeded() {
+			return false
+		}
+	}
+	return true
+}
+
+// Succeeded returns true if the last attempt of the follow-up call received a successful response
+func (r *FollowUpResult) Succeeded() bool {
+	return succeeded(r.StatusCode, r.Error)
+}
+
+// TerminationMessage serializes the Result, leaving out the response body and the successful follow-up calls
+// and truncating the errors when needed to fit in the termination message of the Job
+func (r *Result) TerminationMessage() ([]byte, error) {
+	raw, err := json.Marshal(r)
+	if err != nil || len(raw) <= maxTerminationMessageBytes {
+		return raw, err
+	}
+
+	capped := *r
+	capped.Response = ""
+	capped.Error = truncateUTF8([]byte(r.Error), maxResponseBytes)
+	capped.FollowUps = nil
+	for _, followUp := range r.FollowUps {
+		if followUp.Succeeded() {
+			continue
+		}
+		followUp.URL = truncateUTF8([]byte(followUp.URL), maxTruncatedBytes)
+		followUp.Error = truncateUTF8([]byte(followUp.Error), maxTruncatedBytes)
+		capped.FollowUps = append(capped.FollowUps, followUp)
+	}
+	// Keep at least the first failed follow-up call so that the Result still reads as failed
+	for len(capped.FollowUps) > 0 {
+		if raw, err = json.Marshal(&capped); err != nil || len(raw) <= maxTerminationMessageBytes || len(capped.FollowUps) == 1 {
+			return raw, err
+		}
+		capped.FollowUps = capped.FollowUps[:len(capped.FollowUps)-1]
+	}
+	return json.Marshal(&capped)
+}
+
+func succeeded(statusCode int, err string) bool {
+	return err == "" && statusCode >= 200 && statusCode < 300
+}
+
+// RequestFromEnv reads the Request the controller serialized into the environment of the invocation Job
+func RequestFromEnv() (*Request, error) {
+	raw := os.Getenv(RequestEnvVar)
+	if raw == "" {
+		return nil, fmt.Errorf("Environment variable %s is not set", RequestEnvVar)
+	}
+	req := &Request{}
+	if err := json.Unmarshal([]byte(raw), req); err != nil {
+		return nil, fmt.Errorf("Unable to parse the invocation request: %v", err)
+	}
+	return req, nil
+}
+
+// NewClient returns an HTTP client that trusts the CA and presents the client certificate of the given TLS settings
+func NewClient(tlsConfig *TLSConfig) (*http.Client, error) {
+	if tlsConfig == nil {
+		return http.DefaultClient, nil
+	}
+
+	var caBundle, cert, key []byte
+	var err error
+	if tlsConfig.CAFile != "" {
+		caBundle, err = ioutil.ReadFile(tlsConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read the CA bundle: %v", err)
+		}
+	}
+	if tlsConfig.CertFile != "" || tlsConfig.KeyFile != "" {
+		cert, err = ioutil.ReadFile(tlsConfig.CertFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load the client certificate: %v", err)
+		}
+		key, err = ioutil.ReadFile(tlsConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load the client certificate: %v", err)
+		}
+	}
+	return NewClientFromPEM(caBundle, cert, key, tlsConfig.ServerName)
+}
+
+// NewClientFromPEM returns an HTTP client for the given PEM encoded certificates.
+// Without CA bundle the system roots are trusted and without certificate no client certificate is presented.
+func NewClientFromPEM(caBundle, cert, key []byte, serverName string) (*http.Client, error) {
+	config := &tls.Config{
+		ServerName: serverName,
+	}
+	if caBundle != nil {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("No valid certificate found in the CA bundle")
+		}
+	}
+	if cert != nil || key != nil {
+		keyPair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load the client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{keyPair}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
+
+// ScheduledTimeFromJobName returns the time a Job was scheduled for by its CronJob.
+// The CronJob controller names its Jobs after the scheduled time, in minutes since the epoch.
+func ScheduledTimeFromJobName(jobName string) (time.Time, error) {
+	idx := strings.LastIndex(jobName, "-")
+	if idx == -1 {
+		return time.Time{}, fmt.Errorf("Job %s has not been created by a CronJob", jobName)
+	}
+	minutes, err := strconv.ParseInt(jobName[idx+1:], 10, 64)
+	if err != nil || minutes <= 0 {
+		return time.Time{}, fmt.Errorf("Job %s has not been created by a CronJob", jobName)
+	}
+	return time.Unix(minutes*60, 0).UTC(), nil
+}
+
+// EventID returns an identifier that is the same for every call made for the given trigger and scheduled time,
+// so functions can deduplicate retried invocations
+func EventID(triggerUID string, scheduledTime time.Time) string {
+	sum := sha256.Sum256([]byte(triggerUID + "/" + scheduledTime.UTC().Format(time.RFC3339)))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (req *Request) eventID() string {
+	if req.EventID != "" {
+		return req.EventID
+	}
+	if req.ScheduledTime != nil {
+		return EventID(req.TriggerUID, *req.ScheduledTime)
+	}
+	return ""
+}
+
+// Excluded returns the reason to skip the call if its scheduled time falls in one of the exclusions of the request
+func (req *Request) Excluded() string {
+	t := time.Now()
+	if req.ScheduledTime != nil {
+		t = *req.ScheduledTime
+	}
+	for _, exclusion := range req.Exclusions {
+		if !t.Before(exclusion.Start) && t.Before(exclusion.End) {
+			return exclusion.Reason
+		}
+	}
+	return ""
+}
+
+// Deadline returns the longest time Invoke may take to process the request, including retries and follow-up calls
+func (req *Request) Deadline() time.Duration {
+	attempts := maxAttempts(req.Retry)
+	timeout := time.Duration(req.TimeoutSeconds) * time.Second
+	deadline := time.Duration(attempts) * timeout
+	backoff := initialBackoff(req.Retry)
+	for i := int32(1); i < attempts; i++ {
+		deadline += backoff
+		backoff = nextBackoff(req.Retry, backoff)
+	}
+	followUps := len(req.OnSuccess)
+	if len(req.OnFailure) > followUps {
+		followUps = len(req.OnFailure)
+	}
+	deadline *= time.Duration(1 + followUps)
+	if req.Jitter != nil {
+		deadline += req.Jitter.Duration
+	}
+	return deadline
+}
+
+// Delay returns a random delay, below the jitter of the request, to wait before the first attempt
+func (req *Request) Delay() time.Duration {
+	if req.Jitter == nil || req.Jitter.Duration <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(req.Jitter.Duration)))
+}
+
+// Invoke sends the request to its function after a random delay below its jitter,
+// retrying failed attempts according to the request retry policy, then makes the follow-up calls
+// for its outcome in order, until one of them fails.
+// The request is not sent if it is scheduled during one of its exclusions.
+func Invoke(ctx context.Context, client *http.Client, req *Request) *Result {
+	startTime := time.Now().UTC()
+	result := &Result{ScheduledTime: req.ScheduledTime, StartTime: &startTime}
+	defer func() {
+		completionTime := time.Now().UTC()
+		result.CompletionTime = &completionTime
+	}()
+	if reason := req.Excluded(); reason != "" {
+		result.Skipped = reason
+		return result
+	}
+	if delay := req.Delay(); delay > 0 {
+		logrus.Infof("Delaying the call to %s by %s", req.URL, delay)
+		select {
+		case <-ctx.Done():
+			result.Error = ctx.Err().Error()
+			return result
+		case <-time.After(delay):
+		}
+	}
+	resp := call(ctx, client, req, result)
+
+	followUps := req.OnSuccess
+	if !result.Succeeded() {
+		followUps = req.OnFailure
+	}
+	for _, followUp := range followUps {
+		followUpReq := req.followUp(followUp, resp)
+		followUpResult := &Result{}
+		resp = call(ctx, client, followUpReq, followUpResult)
+		result.FollowUps = append(result.FollowUps, FollowUpResult{
+			URL:        followUpReq.URL,
+			StatusCode: followUpResult.StatusCode,
+			Attempts:   followUpResult.Attempts,
+			Error:      followUpResult.Error,
+		})
+		if !followUpResult.Succeeded() {
+			logrus.Infof("Follow-up call to %s failed, skipping the next ones", followUpReq.URL)
+			break
+		}
+	}
+	return result
+}
+
+// followUp returns the request of a follow-up call, made after the given response to the request
+func (req *Request) followUp(followUp FollowUp, resp *response) *Request {
+	followUpReq := &Request{
+		URL:            followUp.URL,
+		Payload:        req.Payload,
+		ContentType:    req.ContentType,
+		EventID:        req.eventID(),
+		ScheduledTime:  req.ScheduledTime,
+		TimeoutSeconds: req.TimeoutSeconds,
+		Retry:          req.Retry,
+	}
+	if followUp.PassResponse {
+		followUpReq.Payload = ""
+		followUpReq.ContentType = ""
+		if resp != nil {
+			followUpReq.Payload = string(resp.body)
+			followUpReq.ContentType = resp.contentType
+		}
+	}
+	return followUpReq
+}
+
+// call sends the request, retrying failed attempts according to its retry policy, and records the outcome in the result.
+// It returns the last response received, nil if none
+func call(ctx context.Context, client *http.Client, req *Request, result *Result) *response {
+	backoff := initialBackoff(req.Retry)
+	for {
+		result.Attempts++
+		sentTime := time.Now()
+		resp, err := send(ctx, client, req, result.Attempts)
+		result.StatusCode = 0
+		result.Response = ""
+		if resp != nil {
+			result.StatusCode = resp.statusCode
+			result.Response = truncateUTF8(resp.body, maxResponseBytes)
+		}
+		result.Latency = &metav1.Duration{Duration: time.Since(sentTime)}
+		result.Error = ""
+		if err != nil {
+			result.Error = err.Error()
+		}
+		if result.Succeeded() || result.Attempts >= maxAttempts(req.Retry) || !retryable(req.Retry, result.StatusCode, err) {
+			return resp
+		}
+
+		logrus.Infof("Attempt %d to call %s failed (status code: %d, error: %v), retrying in %s", result.Attempts, req.URL, result.StatusCode, err, backoff)
+		select {
+		case <-ctx.Done():
+			return resp
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(req.Retry, backoff)
+	}
+}
+
+// response is the part of an HTTP response kept by the invoker
+type response struct {
+	statusCode  int
+	contentType string
+	body        []byte
+}
+
+// send makes a single attempt of the request and returns the response, nil if none was received
+func send(ctx context.Context, client *http.Client, req *Request, attempt int32) (*response, error) {
+	if req.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.TimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+
+	method := http.MethodGet
+	var body io.Reader
+	if req.Payload != "" {
+		method = http.MethodPost
+		body = strings.NewReader(req.Payload)
+	}
+	httpReq, err := http.NewRequest(method, req.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	httpReq.Header.Set("Event-Id", req.eventID())
+	httpReq.Header.Set("Event-Time", time.Now().UTC().Format(eventTimeFormat))
+	if req.ScheduledTime != nil {
+		httpReq.Header.Set("Event-Scheduled-Time", req.ScheduledTime.UTC().Format(eventTimeFormat))
+	}
+	httpReq.Header.Set("Event-Namespace", eventNamespace)
+	httpReq.Header.Set("Event-Type", contentType)
+	httpReq.Header.Set("Event-Attempt", strconv.Itoa(int(attempt)))
+	httpReq.Header.Set("Content-Type", contentType)
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	resp := &response{statusCode: httpResp.StatusCode, contentType: httpResp.Header.Get("Content-Type")}
+	resp.body, err = ioutil.ReadAll(io.LimitReader(httpResp.Body, maxPassedResponseBytes))
+	if err != nil {
+		return resp, err
+	}
+	io.Copy(ioutil.Discard, httpResp.Body)
+	return resp, nil
+}
+
+// truncateUTF8 returns the beginning of the body, up to the given size, without an incomplete character at its end
+func truncateUTF8(body []byte, size int) string {
+	if len(body) <= size {
+		return string(body)
+	}
+	body = body[:size]
+	for i := 0; i < utf8.UTFMax && len(body) > 0 && !utf8.Valid(body); i++ {
+		body = body[:len(body)-1]
+	}
+	return string(body)
+}
+
+func retryable(policy *cronjobTriggerApi.RetryPolicy, statusCode int, err error) bool {
+	// Connection errors are usually caused by functions that are not ready yet
+	if err != nil {
+		return true
+	}
+	codes := defaultRetryableStatusCodes
+	if policy != nil && len(policy.RetryableStatusCodes) != 0 {
+		codes = policy.RetryableStatusCodes
+	}
+	for _, code := range codes {
+		if int(code) == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+func maxAttempts(policy *cronjobTriggerApi.RetryPolicy) int32 {
+	if policy == nil || policy.MaxAttempts < 1 {
+		return defaultMaxAttempts
+	}
+	return policy.MaxAttempts
+}
+
+func initialBackoff(policy *cronjobTriggerApi.RetryPolicy) time.Duration {
+	if policy == nil || policy.InitialBackoff == nil {
+		return defaultInitialBackoff
+	}
+	return policy.InitialBackoff.Duration
+}
+
+func nextBackoff(policy *cronjobTriggerApi.RetryPolicy, backoff time.Duration) time.Duration {
+	limit := defaultMaxBackoff
+	if policy != nil && policy.MaxBackoff != nil {
+		limit = policy.MaxBackoff.Duration
+	}
+	backoff *= 2
+	if backoff > limit {
+		return limit
+	}
+	return backoff
+}
@@ -0,0 +1,451 @@
+package invoker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	cronjobTriggerApi "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInvoke(t *testing.T) {
+	var method, body string
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		headers = r.Header
+		raw, _ := ioutil.ReadAll(r.Body)
+		body = string(raw)
+	}))
+	defer server.Close()
+
+	result := Invoke(context.TODO(), http.DefaultClient, &Request{
+		URL:     server.URL,
+		Payload: `{"test":"foo"}`,
+		EventID: "1234",
+	})
+	if !result.Succeeded() || result.Attempts != 1 || result.StatusCode != http.StatusOK {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if method != http.MethodPost || body != `{"test":"foo"}` {
+		t.Errorf("Unexpected request %s with body %s", method, body)
+	}
+	expectedHeaders := map[string]string{
+		"Event-Id":        "1234",
+		"Event-Namespace": "cronjobtrigger.kubeless.io",
+		"Event-Type":      "application/json",
+		"Event-Attempt":   "1",
+		"Content-Type":    "application/json",
+	}
+	for header, value := range expectedHeaders {
+		if headers.Get(header) != value {
+			t.Errorf("Unexpected header %s: %s, expecting %s", header, headers.Get(header), value)
+		}
+	}
+	if _, err := time.Parse(eventTimeFormat, headers.Get("Event-Time")); err != nil {
+		t.Errorf("Unexpected Event-Time header %s: %v", headers.Get("Event-Time"), err)
+	}
+
+	Invoke(context.TODO(), http.DefaultClient, &Request{URL: server.URL})
+	if method != http.MethodGet || body != "" {
+		t.Errorf("Requests without payload should be sent as GET, received %s with body %s", method, body)
+	}
+}
+
+func TestInvokeResponse(t *testing.T) {
+	response := "ok"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	result := Invoke(context.TODO(), http.DefaultClient, &Request{URL: server.URL})
+	if result.Response != "ok" || result.Latency == nil || result.Latency.Duration <= 0 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	// Long responses are truncated without splitting a character
+	response = strings.Repeat("a", maxResponseBytes-1) + "é"
+	result = Invoke(context.TODO(), http.DefaultClient, &Request{URL: server.URL})
+	if result.Response != strings.Repeat("a", maxResponseBytes-1) {
+		t.Errorf("Unexpected response of %d bytes", len(result.Response))
+	}
+}
+
+func TestInvokeExclusions(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	scheduledTime := time.Date(2018, 12, 25, 10, 0, 0, 0, time.UTC)
+	req := &Request{
+		URL:           server.URL,
+		ScheduledTime: &scheduledTime,
+		Exclusions: []Exclusion{
+			{Start: time.Date(2018, 12, 25, 0, 0, 0, 0, time.UTC), End: time.Date(2018, 12, 26, 0, 0, 0, 0, time.UTC), Reason: "Christmas"},
+		},
+	}
+	result := Invoke(context.TODO(), http.DefaultClient, req)
+	if result.Skipped != "Christmas" || result.Attempts != 0 || calls != 0 {
+		t.Errorf("Unexpected result %+v after %d calls", result, calls)
+	}
+
+	// The end of an exclusion is not excluded
+	scheduledTime = time.Date(2018, 12, 26, 0, 0, 0, 0, time.UTC)
+	result = Invoke(context.TODO(), http.DefaultClient, req)
+	if !result.Succeeded() || result.Skipped != "" || calls != 1 {
+		t.Errorf("Unexpected result %+v after %d calls", result, calls)
+	}
+}
+
+func TestInvokeEventHeaders(t *testing.T) {
+	var eventIDs, scheduledTimes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		eventIDs = append(eventIDs, r.Header.Get("Event-Id"))
+		scheduledTimes = append(scheduledTimes, r.Header.Get("Event-Scheduled-Time"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	scheduledTime := time.Date(2018, 3, 5, 5, 55, 0, 0, time.UTC)
+	req := &Request{
+		URL:           server.URL,
+		TriggerUID:    "3c2b7d1e-1234",
+		ScheduledTime: &scheduledTime,
+		Retry: &cronjobTriggerApi.RetryPolicy{
+			MaxAttempts:    2,
+			InitialBackoff: &metav1.Duration{Duration: time.Millisecond},
+		},
+	}
+	Invoke(context.TODO(), http.DefaultClient, req)
+	// A second run for the same schedule, as if the pod was restarted
+	Invoke(context.TODO(), http.DefaultClient, req)
+
+	expectedID := EventID("3c2b7d1e-1234", scheduledTime)
+	if len(eventIDs) != 4 {
+		t.Fatalf("Unexpected number of calls: %d", len(eventIDs))
+	}
+	for i := range eventIDs {
+		if eventIDs[i] != expectedID {
+			t.Errorf("Unexpected Event-Id %s, expecting %s", eventIDs[i], expectedID)
+		}
+		if scheduledTimes[i] != "2018-03-05 05:55:00+00:00" {
+			t.Errorf("Unexpected Event-Scheduled-Time %s", scheduledTimes[i])
+		}
+	}
+	if EventID("3c2b7d1e-1234", scheduledTime.Add(time.Minute)) == expectedID {
+		t.Errorf("Runs scheduled at different times should have different event IDs")
+	}
+	if EventID("another-trigger", scheduledTime) == expectedID {
+		t.Errorf("Runs of different triggers should have different event IDs")
+	}
+}
+
+func TestScheduledTimeFromJobName(t *testing.T) {
+	scheduledTime, err := ScheduledTimeFromJobName("trigger-foo-25333919")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !scheduledTime.Equal(time.Date(2018, 3, 2, 23, 59, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected scheduled time %s", scheduledTime)
+	}
+	for _, jobName := range []string{"", "trigger-foo", "trigger-foo-bar"} {
+		if _, err := ScheduledTimeFromJobName(jobName); err == nil {
+			t.Errorf("Expecting an error for Job %s", jobName)
+		}
+	}
+}
+
+func TestInvokeRetries(t *testing.T) {
+	var attempts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts = append(attempts, r.Header.Get("Event-Attempt"))
+		if len(attempts) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	retry := &cronjobTriggerApi.RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: &metav1.Duration{Duration: time.Millisecond},
+		MaxBackoff:     &metav1.Duration{Duration: 2 * time.Millisecond},
+	}
+	result := Invoke(context.TODO(), http.DefaultClient, &Request{URL: server.URL, Retry: retry})
+	if !result.Succeeded() || result.Attempts != 3 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if len(attempts) != 3 || attempts[0] != "1" || attempts[2] != "3" {
+		t.Errorf("Unexpected attempt headers: %v", attempts)
+	}
+
+	// Exhaust the attempts
+	attempts = nil
+	retry.MaxAttempts = 2
+	result = Invoke(context.TODO(), http.DefaultClient, &Request{URL: server.URL, Retry: retry})
+	if result.Succeeded() || result.Attempts != 2 || result.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	// Status codes not listed in the policy are not retried
+	attempts = nil
+	retry.MaxAttempts = 5
+	retry.RetryableStatusCodes = []int32{http.StatusInternalServerError}
+	result = Invoke(context.TODO(), http.DefaultClient, &Request{URL: server.URL, Retry: retry})
+	if result.Succeeded() || result.Attempts != 1 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestInvokeFollowUps(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		calls = append(calls, r.URL.Path+" "+string(body))
+		switch r.URL.Path {
+		case "/extract":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Write([]byte("a,b"))
+		case "/transform":
+			if r.Header.Get("Content-Type") != "text/csv" {
+				t.Errorf("Unexpected content type %s", r.Header.Get("Content-Type"))
+			}
+			w.Write([]byte(strings.ToUpper(string(body))))
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	req := &Request{
+		URL:     server.URL + "/extract",
+		Payload: `{"day":"monday"}`,
+		OnSuccess: []FollowUp{
+			{URL: server.URL + "/transform", PassResponse: true},
+			{URL: server.URL + "/load", PassResponse: true},
+			{URL: server.URL + "/notify"},
+		},
+		OnFailure: []FollowUp{{URL: server.URL + "/cleanup"}},
+	}
+	result := Invoke(context.TODO(), http.DefaultClient, req)
+	if !result.Succeeded() || len(result.FollowUps) != 3 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	expected := []string{"/extract {\"day\":\"monday\"}", "/transform a,b", "/load A,B", "/notify {\"day\":\"monday\"}"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Unexpected calls %q, expecting %q", calls, expected)
+	}
+
+	// The chain stops at the first failed follow-up, which fails the run
+	calls = nil
+	req.OnSuccess = []FollowUp{{URL: server.URL + "/broken"}, {URL: server.URL + "/notify"}}
+	result = Invoke(context.TODO(), http.DefaultClient, req)
+	if result.Succeeded() || len(result.FollowUps) != 1 || result.FollowUps[0].StatusCode != http.StatusInternalServerError {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if len(calls) != 2 {
+		t.Errorf("Unexpected calls %q", calls)
+	}
+
+	// Failed calls are followed by the onFailure targets
+	calls = nil
+	req.URL = server.URL + "/broken"
+	result = Invoke(context.TODO(), http.DefaultClient, req)
+	if result.Succeeded() || len(result.FollowUps) != 1 || result.FollowUps[0].URL != server.URL+"/cleanup" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestTerminationMessage(t *testing.T) {
+	result := &Result{StatusCode: 200, Attempts: 1, Response: "ok"}
+	raw, err := result.TerminationMessage()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(raw) != `{"statusCode":200,"attempts":1,"response":"ok"}` {
+		t.Errorf("Unexpected termination message %s", raw)
+	}
+
+	large := strings.Repeat("é", maxTerminationMessageBytes)
+	result = &Result{StatusCode: 200, Attempts: 1, Response: large}
+	for i := 0; i < 20; i++ {
+		result.FollowUps = append(result.FollowUps,
+			FollowUpResult{URL: "http://ok/" + large, StatusCode: 200, Attempts: 1},
+			FollowUpResult{URL: "http://ko/" + large, Attempts: 3, Error: large},
+		)
+	}
+	raw, err = result.TerminationMessage()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(raw) > maxTerminationMessageBytes {
+		t.Fatalf("Expecting at most %d bytes, got %d", maxTerminationMessageBytes, len(raw))
+	}
+	capped := &Result{}
+	if err := json.Unmarshal(raw, capped); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if capped.Response != "" || len(capped.FollowUps) == 0 || capped.StatusCode != 200 {
+		t.Errorf("Unexpected result %+v", capped)
+	}
+	if capped.Succeeded() {
+		t.Error("Expecting the failed follow-up calls to be kept")
+	}
+	for _, followUp := range capped.FollowUps {
+		if !strings.HasPrefix(followUp.URL, "http://ko/") || !utf8.ValidString(followUp.Error) {
+			t.Errorf("Unexpected follow-up call %+v", followUp)
+		}
+	}
+}
+
+func TestInvokeTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "invoker-tls")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCert, clientKey := generateCertificate(t)
+	clientCertPool := x509.NewCertPool()
+	clientCertPool.AppendCertsFromPEM(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCertPool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	ioutil.WriteFile(certFile, clientCert, 0600)
+	ioutil.WriteFile(keyFile, clientKey, 0600)
+
+	// The certificate of the test server is issued for example.com
+	client, err := NewClient(&TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "example.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	result := Invoke(context.TODO(), client, &Request{URL: server.URL})
+	if !result.Succeeded() {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	// Without client certificate the server rejects the call
+	client, err = NewClient(&TLSConfig{CAFile: caFile, ServerName: "example.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	result = Invoke(context.TODO(), client, &Request{URL: server.URL})
+	if result.Succeeded() {
+		t.Errorf("The call should fail without client certificate")
+	}
+
+	// Without the CA the server certificate can't be verified
+	client, err = NewClient(&TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	result = Invoke(context.TODO(), client, &Request{URL: server.URL})
+	if result.Succeeded() {
+		t.Errorf("The call should fail with an unknown CA")
+	}
+
+	if _, err := NewClient(&TLSConfig{CAFile: filepath.Join(dir, "missing.crt")}); err == nil {
+		t.Errorf("Expecting an error for a missing CA bundle")
+	}
+}
+
+func generateCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cronjob-trigger"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey})
+}
+
+func TestRequestDeadline(t *testing.T) {
+	req := &Request{TimeoutSeconds: 10}
+	if req.Deadline() != 10*time.Second {
+		t.Errorf("Unexpected deadline %s", req.Deadline())
+	}
+	req.Retry = &cronjobTriggerApi.RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: &metav1.Duration{Duration: time.Second},
+		MaxBackoff:     &metav1.Duration{Duration: 3 * time.Second},
+	}
+	// 4 attempts of 10s plus 1s, 2s and 3s of backoff
+	if req.Deadline() != 46*time.Second {
+		t.Errorf("Unexpected deadline %s", req.Deadline())
+	}
+	req.Jitter = &metav1.Duration{Duration: time.Minute}
+	if req.Deadline() != 106*time.Second {
+		t.Errorf("Unexpected deadline %s", req.Deadline())
+	}
+}
+
+func TestRequestDelay(t *testing.T) {
+	req := &Request{}
+	if req.Delay() != 0 {
+		t.Errorf("Unexpected delay %s", req.Delay())
+	}
+	req.Jitter = &metav1.Duration{Duration: time.Minute}
+	for i := 0; i < 100; i++ {
+		if delay := req.Delay(); delay < 0 || delay >= time.Minute {
+			t.Fatalf("Unexpected delay %s", delay)
+		}
+	}
+}
+
+func TestRequestFromEnv(t *testing.T) {
+	os.Setenv(RequestEnvVar, `{"url":"http://foo.default.svc.cluster.local:8080","timeoutSeconds":180}`)
+	defer os.Unsetenv(RequestEnvVar)
+	req, err := RequestFromEnv()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.URL != "http://foo.default.svc.cluster.local:8080" || req.TimeoutSeconds != 180 {
+		t.Errorf("Unexpected request %+v", req)
+	}
+}
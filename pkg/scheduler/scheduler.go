@@ -0,0 +1,487 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cronjobTriggerApi "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/cronjob-trigger/pkg/invoker"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWorkers is the number of invocations a scheduler runs concurrently by default
+const DefaultWorkers = 10
+
+// SkippedBusy is the reason reported for the runs skipped because no worker got free in time
+const SkippedBusy = "All the workers of the scheduler are busy"
+
+// SkippedStopped is the reason reported for the runs given up because the scheduler stopped before making them,
+// such as the delayed runs of a replica losing the leadership
+const SkippedStopped = "The scheduler stopped before making the run"
+
+// Time a due run waits for a free worker before being skipped
+const defaultEnqueueTimeout = 30 * time.Second
+
+// Invocation is a call made on every run of a schedule
+type Invocation struct {
+	Request *invoker.Request
+	Client  *http.Client
+}
+
+// ResultFunc receives the outcome of an invocation made for the entry with the given key
+type ResultFunc func(key string, scheduledTime time.Time, result *invoker.Result)
+
+type entry struct {
+	spec        string
+	schedule    cron.Schedule
+	invocations []Invocation
+	next        time.Time
+	// Runs waiting for their delay, stopped when the entry is removed and given up when the scheduler stops
+	delayed map[*time.Timer]run
+	// Missed runs waiting to be dispatched, and the scheduled time of the last one caught up
+	missed   []run
+	caughtUp time.Time
+}
+
+type run struct {
+	key           string
+	invocation    Invocation
+	scheduledTime time.Time
+}
+
+// Scheduler makes the invocations of its entries following their schedules, using a bounded pool of workers.
+// Entries can be managed at any time, but invocations are only made while Run is running.
+type Scheduler struct {
+	logger         *logrus.Entry
+	workers        int
+	enqueueTimeout time.Duration
+	now            func() time.Time
+	onResult       ResultFunc
+
+	lock    sync.Mutex
+	entries map[string]*entry
+	wake    chan struct{}
+}
+
+// Schedules run by the scheduler accept an optional seconds field on top of the CronJob format
+var parser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ParseSchedule parses a schedule in the format of the CronJob resources, with an optional leading seconds field,
+// or an interval such as "@every 15s"
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	schedule, err := parser.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid schedule %q: %v", spec, err)
+	}
+	return schedule, nil
+}
+
+// Bounds of the fields of a schedule, the seconds are only used in six field schedules.
+// The days of the month stop at 28 so that hashed days exist in every month.
+var fieldBounds = [][2]int{{0, 59}, {0, 59}, {0, 23}, {1, 28}, {1, 12}, {0, 6}}
+
+// Hashed values such as H, H(0-29), H/15 or H(0-29)/10
+var hashExpression = regexp.MustCompile(`^H(?:\((\d+)-(\d+)\))?(?:/(\d+))?$`)
+
+// ExpandHashes replaces the H values of a schedule by values spread over the range of their field.
+// The values are derived from the given seed, so a trigger always gets the same schedule.
+func ExpandHashes(spec, seed string) (string, error) {
+	fields := strings.Fields(spec)
+	if !strings.Contains(spec, "H") || strings.HasPrefix(spec, "@") || len(fields) < 5 || len(fields) > 6 {
+		return spec, nil
+	}
+	bounds := fieldBounds[1:]
+	if len(fields) == 6 {
+		bounds = fieldBounds
+	}
+	for i, field := range fields {
+		values := strings.Split(field, ",")
+		for j, value := range values {
+			if !strings.HasPrefix(value, "H") {
+				continue
+			}
+			expanded, err := expandHash(value, bounds[i][0], bounds[i][1], fmt.Sprintf("%s/%d", seed, i))
+			if err != nil {
+				return "", fmt.Errorf("Invalid schedule %q: %v", spec, err)
+			}
+			values[j] = expanded
+		}
+		fields[i] = strings.Join(values, ",")
+	}
+	return strings.Join(fields, " "), nil
+}
+
+func expandHash(value string, min, max int, seed string) (string, error) {
+	match := hashExpression.FindStringSubmatch(value)
+	if match == nil {
+		return "", fmt.Errorf("unsupported hash expression %s", value)
+	}
+	if match[1] != "" {
+		min, _ = strconv.Atoi(match[1])
+		rangeMax, _ := strconv.Atoi(match[2])
+		if min > rangeMax || min < 0 || rangeMax > max {
+			return "", fmt.Errorf("range of %s is out of bounds", value)
+		}
+		max = rangeMax
+	}
+	h := fnv.New32a()
+	h.Write([]byte(seed))
+	sum := int(h.Sum32() & 0x7fffffff)
+	if match[3] == "" {
+		return strconv.Itoa(min + sum%(max-min+1)), nil
+	}
+	step, _ := strconv.Atoi(match[3])
+	if step == 0 {
+		return "", fmt.Errorf("step of %s must be positive", value)
+	}
+	offset := sum % step
+	if offset > max-min {
+		offset = sum % (max - min + 1)
+	}
+	return fmt.Sprintf("%d-%d/%d", min+offset, max, step), nil
+}
+
+// ValidateCronJobSchedule returns an error if the schedule can't be run by a CronJob
+func ValidateCronJobSchedule(spec string) error {
+	if strings.HasPrefix(strings.TrimSpace(spec), "@every") {
+		return fmt.Errorf("Interval schedule %q is only supported by the %s execution mode", spec, cronjobTriggerApi.ExecutionModeController)
+	}
+	if _, err := cron.ParseStandard(spec); err != nil {
+		if _, err := parser.Parse(spec); err == nil {
+			return fmt.Errorf("Schedule %q with seconds is only supported by the %s execution mode", spec, cronjobTriggerApi.ExecutionModeController)
+		}
+		return fmt.Errorf("Invalid schedule %q: %v", spec, err)
+	}
+	return nil
+}
+
+// New returns a scheduler running at most the given number of invocations at the same time
+func New(workers int) *Scheduler {
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	return &Scheduler{
+		logger:         logrus.WithField("component", "scheduler"),
+		workers:        workers,
+		enqueueTimeout: defaultEnqueueTimeout,
+		now: func() time.Time {
+			return time.Now().UTC()
+		},
+		entries: map[string]*entry{},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// OnResult registers a function receiving the outcome of every invocation. It must be called before Run
+func (s *Scheduler) OnResult(f ResultFunc) {
+	s.onResult = f
+}
+
+// Set creates or replaces the entry with the given key
+func (s *Scheduler) Set(key, spec string, invocations []Invocation) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.entries[key]
+	if ok && e.spec == spec {
+		// Keep the next run of unchanged schedules
+		e.invocations = invocations
+		return nil
+	}
+	updated := &entry{
+		spec:        spec,
+		schedule:    schedule,
+		invocations: invocations,
+		next:        schedule.Next(s.now()),
+		delayed:     map[*time.Timer]run{},
+	}
+	if ok {
+		// Runs of the previous schedule waiting for their delay or caught up are still made
+		updated.delayed = e.delayed
+		updated.missed = e.missed
+		updated.caughtUp = e.caughtUp
+	}
+	s.entries[key] = updated
+	s.notify()
+	return nil
+}
+
+// CatchUp makes the invocations of the entry with the given key for a run missed at the given time, as soon as
+// Run is running. Runs scheduled before the last one caught up for the entry are ignored, they have been made already
+func (s *Scheduler) CatchUp(key string, scheduledTime time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return fmt.Errorf("Unable to catch up the run of %s, it is not scheduled", key)
+	}
+	if !scheduledTime.After(e.caughtUp) {
+		return nil
+	}
+	for _, invocation := range e.invocations {
+		e.missed = append(e.missed, run{key: key, invocation: invocation, scheduledTime: scheduledTime})
+	}
+	e.caughtUp = scheduledTime
+	s.notify()
+	return nil
+}
+
+// Remove deletes the entry with the given key, if any, along with its runs waiting for their delay
+func (s *Scheduler) Remove(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.entries[key]; ok {
+		for timer := range e.delayed {
+			timer.Stop()
+			delete(e.delayed, timer)
+		}
+		delete(s.entries, key)
+		s.notify()
+	}
+}
+
+// Next returns the time of the next run of the entry with the given key
+func (s *Scheduler) Next(key string) (time.Time, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return e.next, true
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run makes the scheduled invocations until stopCh is closed, then gives up the runs it has not made yet
+func (s *Scheduler) Run(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	runs := make(chan run, s.workers)
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runWorker(ctx, runs)
+		}()
+	}
+	defer func() {
+		wg.Wait()
+		// Runs queued after the workers stopped are given up
+		for {
+			select {
+			case r := <-runs:
+				s.giveUp(r)
+			default:
+				return
+			}
+		}
+	}()
+
+	s.logger.Infof("Starting scheduler with %d workers", s.workers)
+
+	// Runs missed while the scheduler was stopped are skipped
+	s.lock.Lock()
+	for _, e := range s.entries {
+		e.next = e.schedule.Next(s.now())
+	}
+	s.lock.Unlock()
+
+	for {
+		next := s.dispatch(ctx, runs)
+
+		// Without entries, wait until one is set
+		var timer *time.Timer
+		var timerCh <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(s.now()))
+			timerCh = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Stopping scheduler")
+			s.giveUpDelayed()
+			return
+		case <-s.wake:
+		case <-timerCh:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// dispatch queues the runs that are due and returns the time of the next one
+func (s *Scheduler) dispatch(ctx context.Context, runs chan<- run) time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	var next time.Time
+	for key, e := range s.entries {
+		for _, r := range e.missed {
+			s.dispatchRun(ctx, runs, e, r)
+		}
+		e.missed = nil
+		if !e.next.After(now) {
+			for _, invocation := range e.invocations {
+				s.dispatchRun(ctx, runs, e, run{key: key, invocation: invocation, scheduledTime: e.next})
+			}
+			e.next = e.schedule.Next(now)
+		}
+		if next.IsZero() || e.next.Before(next) {
+			next = e.next
+		}
+	}
+	return next
+}
+
+// dispatchRun queues the run of the entry, after its delay if any. It must be called with the lock held
+func (s *Scheduler) dispatchRun(ctx context.Context, runs chan<- run, e *entry, r run) {
+	delay := r.invocation.Request.Delay()
+	if delay <= 0 {
+		s.enqueue(ctx, runs, r)
+		return
+	}
+	// Delayed runs don't hold a worker while they wait
+	req := *r.invocation.Request
+	req.Jitter = nil
+	r.invocation.Request = &req
+	s.delay(ctx, runs, e, r, delay)
+}
+
+// delay queues the run of the entry once the delay has passed, unless the entry is removed or the scheduler
+// stopped before. It must be called with the lock held
+func (s *Scheduler) delay(ctx context.Context, runs chan<- run, e *entry, r run, delay time.Duration) {
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		s.lock.Lock()
+		_, pending := e.delayed[timer]
+		delete(e.delayed, timer)
+		s.lock.Unlock()
+		if pending {
+			s.enqueue(ctx, runs, r)
+		}
+	})
+	e.delayed[timer] = r
+}
+
+// giveUpDelayed stops the runs waiting for their delay or to be caught up, the workers that would make them
+// are stopping. The next Run doesn't make them either, another replica may be leading by then
+func (s *Scheduler) giveUpDelayed() {
+	s.lock.Lock()
+	var delayed []run
+	for _, e := range s.entries {
+		for timer, r := range e.delayed {
+			timer.Stop()
+			delete(e.delayed, timer)
+			delayed = append(delayed, r)
+		}
+		delayed = append(delayed, e.missed...)
+		e.missed = nil
+	}
+	s.lock.Unlock()
+	for _, r := range delayed {
+		s.giveUp(r)
+	}
+}
+
+// giveUp reports the run as skipped because the scheduler stopped before making it
+func (s *Scheduler) giveUp(r run) {
+	s.logger.Warnf("Giving up the run of %s scheduled at %s, the scheduler is stopping", r.key, r.scheduledTime.Format(time.RFC3339))
+	if s.onResult != nil {
+		scheduledTime := r.scheduledTime
+		s.onResult(r.key, r.scheduledTime, &invoker.Result{Skipped: SkippedStopped, ScheduledTime: &scheduledTime})
+	}
+}
+
+// enqueue hands the run to a worker. When they are all busy, the run waits for one in the background
+// and is reported as skipped if none gets free in time
+func (s *Scheduler) enqueue(ctx context.Context, runs chan<- run, r run) {
+	if ctx.Err() != nil {
+		s.giveUp(r)
+		return
+	}
+	select {
+	case runs <- r:
+		return
+	default:
+	}
+	go func() {
+		timer := time.NewTimer(s.enqueueTimeout)
+		defer timer.Stop()
+		select {
+		case runs <- r:
+		case <-ctx.Done():
+			s.giveUp(r)
+		case <-timer.C:
+			s.logger.Warnf("Skipping the run of %s scheduled at %s, all workers are busy", r.key, r.scheduledTime.Format(time.RFC3339))
+			if s.onResult != nil {
+				scheduledTime := r.scheduledTime
+				s.onResult(r.key, r.scheduledTime, &invoker.Result{Skipped: SkippedBusy, ScheduledTime: &scheduledTime})
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) runWorker(ctx context.Context, runs <-chan run) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-runs:
+			req := *r.invocation.Request
+			req.ScheduledTime = &r.scheduledTime
+			result := invoker.Invoke(ctx, r.invocation.Client, &req)
+			if result.Skipped != "" {
+				s.logger.Infof("Skipped the run of %s scheduled at %s: %s", r.key, r.scheduledTime.Format(time.RFC3339), result.Skipped)
+			} else if result.Succeeded() {
+				s.logger.Infof("Called %s for %s (status code: %d, attempts: %d)", req.URL, r.key, result.StatusCode, result.Attempts)
+			} else {
+				s.logger.Errorf("Failed to call %s for %s after %d attempt(s) (status code: %d, error: %s)", req.URL, r.key, result.Attempts, result.StatusCode, result.Error)
+			}
+			if s.onResult != nil {
+				s.onResult(r.key, r.scheduledTime, result)
+			}
+		}
+	}
+}
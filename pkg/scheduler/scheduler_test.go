@@ -0,0 +1,300 @@
+package scheduler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kubeless/cronjob-trigger/pkg/invoker"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, spec := range []string{"* * * * *", "*/10 0 * * 1-5", "@hourly", "*/15 * * * * *", "@every 15s"} {
+		if _, err := ParseSchedule(spec); err != nil {
+			t.Errorf("Unexpected error for %s: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "* * * *", "61 * * * *", "@every 15"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("Expecting an error for %s", spec)
+		}
+	}
+
+	schedule, _ := ParseSchedule("*/15 * * * * *")
+	next := schedule.Next(time.Date(2018, 3, 5, 5, 55, 16, 0, time.UTC))
+	if !next.Equal(time.Date(2018, 3, 5, 5, 55, 30, 0, time.UTC)) {
+		t.Errorf("Unexpected next run %s", next)
+	}
+}
+
+func TestValidateCronJobSchedule(t *testing.T) {
+	for _, spec := range []string{"* * * * *", "@hourly"} {
+		if err := ValidateCronJobSchedule(spec); err != nil {
+			t.Errorf("Unexpected error for %s: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"*/15 * * * * *", "@every 15s", "61 * * * *"} {
+		if err := ValidateCronJobSchedule(spec); err == nil {
+			t.Errorf("Expecting an error for %s", spec)
+		}
+	}
+}
+
+func TestExpandHashes(t *testing.T) {
+	for _, spec := range []string{"*/5 * * * *", "@hourly", "@every 1h"} {
+		if expanded, _ := ExpandHashes(spec, "uid"); expanded != spec {
+			t.Errorf("Unexpected schedule %s for %s", expanded, spec)
+		}
+	}
+
+	expanded, err := ExpandHashes("H H(0-5) * * H/2", "uid")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if again, _ := ExpandHashes("H H(0-5) * * H/2", "uid"); again != expanded {
+		t.Errorf("The schedule should be stable, got %s and %s", expanded, again)
+	}
+	schedule, err := ParseSchedule(expanded)
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %v", expanded, err)
+	}
+	next := schedule.Next(time.Date(2018, 3, 5, 0, 0, 0, 0, time.UTC))
+	if next.Hour() > 5 || next.Second() != 0 {
+		t.Errorf("Unexpected next run %s for %s", next, expanded)
+	}
+
+	// Triggers get spread over the hour
+	minutes := map[string]bool{}
+	for _, seed := range []string{"a", "b", "c", "d", "e", "f"} {
+		expanded, _ := ExpandHashes("H * * * *", seed)
+		minutes[expanded] = true
+	}
+	if len(minutes) < 2 {
+		t.Errorf("Unexpected schedules %v", minutes)
+	}
+
+	for _, spec := range []string{"HH * * * *", "H(5-1) * * * *", "H(0-60) * * * *", "H/0 * * * *"} {
+		if _, err := ExpandHashes(spec, "uid"); err == nil {
+			t.Errorf("Expecting an error for %s", spec)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	now := time.Date(2018, 3, 5, 5, 55, 30, 0, time.UTC)
+	s := New(2)
+	s.now = func() time.Time { return now }
+
+	invocations := []Invocation{
+		{Request: &invoker.Request{URL: "http://foo.default.svc.cluster.local:8080"}},
+		{Request: &invoker.Request{URL: "http://bar.default.svc.cluster.local:8080"}},
+	}
+	if err := s.Set("default/foo", "*/5 * * * *", invocations); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := s.Set("default/bar", "not a schedule", invocations); err == nil {
+		t.Errorf("Expecting an error for an invalid schedule")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := make(chan run, 2)
+	next := s.dispatch(ctx, runs)
+	expectedNext := time.Date(2018, 3, 5, 6, 0, 0, 0, time.UTC)
+	if !next.Equal(expectedNext) || len(runs) != 0 {
+		t.Errorf("Unexpected next run %s with %d runs queued", next, len(runs))
+	}
+
+	// Setting the same schedule again keeps the next run
+	now = now.Add(time.Minute)
+	s.Set("default/foo", "*/5 * * * *", invocations)
+	if next, _ := s.Next("default/foo"); !next.Equal(expectedNext) {
+		t.Errorf("Unexpected next run %s", next)
+	}
+
+	now = expectedNext.Add(time.Second)
+	next = s.dispatch(ctx, runs)
+	if !next.Equal(expectedNext.Add(5 * time.Minute)) {
+		t.Errorf("Unexpected next run %s", next)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("Unexpected number of runs: %d", len(runs))
+	}
+	r := <-runs
+	if r.key != "default/foo" || !r.scheduledTime.Equal(expectedNext) {
+		t.Errorf("Unexpected run %+v", r)
+	}
+
+	// Runs wait for a worker, and are reported as skipped if none gets free in time
+	skipped := make(chan *invoker.Result, 2)
+	s.OnResult(func(key string, scheduledTime time.Time, result *invoker.Result) {
+		skipped <- result
+	})
+	s.enqueueTimeout = 100 * time.Millisecond
+	now = now.Add(5 * time.Minute)
+	s.dispatch(ctx, runs)
+	select {
+	case result := <-skipped:
+		if result.Skipped != SkippedBusy || result.ScheduledTime == nil || !result.ScheduledTime.Equal(expectedNext.Add(5*time.Minute)) {
+			t.Errorf("Unexpected result %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The run should be reported as skipped")
+	}
+	if len(runs) != 2 {
+		t.Errorf("Unexpected number of runs: %d", len(runs))
+	}
+
+	s.Remove("default/foo")
+	if _, ok := s.Next("default/foo"); ok {
+		t.Errorf("The entry should be removed")
+	}
+}
+
+func TestDispatchWithJitter(t *testing.T) {
+	now := time.Date(2018, 3, 5, 5, 55, 30, 0, time.UTC)
+	s := New(1)
+	s.now = func() time.Time { return now }
+
+	jitter := &metav1.Duration{Duration: 100 * time.Millisecond}
+	invocations := []Invocation{{Request: &invoker.Request{URL: "http://foo.default.svc.cluster.local:8080", Jitter: jitter}}}
+	s.Set("default/foo", "* * * * *", invocations)
+
+	runs := make(chan run, 1)
+	now = now.Add(time.Minute)
+	s.dispatch(context.Background(), runs)
+	select {
+	case r := <-runs:
+		if r.invocation.Request.Jitter != nil {
+			t.Errorf("The jitter should only be applied once")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("The run has not been queued")
+	}
+	if invocations[0].Request.Jitter != jitter {
+		t.Errorf("The request of the entry should not be modified")
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	now := time.Date(2018, 3, 5, 5, 55, 30, 0, time.UTC)
+	s := New(1)
+	s.now = func() time.Time { return now }
+
+	missed := time.Date(2018, 3, 5, 5, 0, 0, 0, time.UTC)
+	if err := s.CatchUp("default/foo", missed); err == nil {
+		t.Errorf("Expecting an error for an unknown entry")
+	}
+	s.Set("default/foo", "0 * * * *", []Invocation{{Request: &invoker.Request{URL: "http://foo.default.svc.cluster.local:8080"}}})
+	for _, scheduledTime := range []time.Time{missed, missed, missed.Add(-time.Hour)} {
+		if err := s.CatchUp("default/foo", scheduledTime); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Runs are only caught up once, in order
+	runs := make(chan run, 3)
+	s.dispatch(context.Background(), runs)
+	if len(runs) != 1 {
+		t.Fatalf("Unexpected number of runs: %d", len(runs))
+	}
+	if r := <-runs; !r.scheduledTime.Equal(missed) {
+		t.Errorf("Unexpected scheduled time %s", r.scheduledTime)
+	}
+	s.dispatch(context.Background(), runs)
+	if len(runs) != 0 {
+		t.Errorf("The missed run should only be dispatched once")
+	}
+}
+
+func TestRemoveDelayedRuns(t *testing.T) {
+	now := time.Date(2018, 3, 5, 5, 55, 30, 0, time.UTC)
+	s := New(1)
+	s.now = func() time.Time { return now }
+
+	jitter := &metav1.Duration{Duration: 100 * time.Millisecond}
+	s.Set("default/foo", "* * * * *", []Invocation{{Request: &invoker.Request{URL: "http://foo.default.svc.cluster.local:8080", Jitter: jitter}}})
+
+	runs := make(chan run, 1)
+	now = now.Add(time.Minute)
+	s.dispatch(context.Background(), runs)
+	s.Remove("default/foo")
+	select {
+	case r := <-runs:
+		t.Errorf("The delayed run of a removed entry should not be queued: %+v", r)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestRunGivesUpDelayedRuns(t *testing.T) {
+	results := make(chan *invoker.Result, 10)
+	s := New(1)
+	s.OnResult(func(key string, scheduledTime time.Time, result *invoker.Result) {
+		results <- result
+	})
+	stopCh := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(stopCh)
+		close(stopped)
+	}()
+
+	jitter := &metav1.Duration{Duration: time.Hour}
+	s.Set("default/foo", "* * * * * *", []Invocation{{Request: &invoker.Request{URL: "http://foo.default.svc.cluster.local:8080", Jitter: jitter}}})
+	delayed := func() int {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return len(s.entries["default/foo"].delayed)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for delayed() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("The run has not been delayed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The delayed runs are reported as skipped once the scheduler stops, instead of being lost
+	close(stopCh)
+	<-stopped
+	select {
+	case result := <-results:
+		if result.Skipped != SkippedStopped {
+			t.Errorf("Unexpected result %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("The delayed run has not been given up")
+	}
+	if delayed() != 0 {
+		t.Errorf("The delayed runs should be stopped")
+	}
+}
+
+func TestRun(t *testing.T) {
+	scheduledTimes := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheduledTimes <- r.Header.Get("Event-Scheduled-Time")
+	}))
+	defer server.Close()
+
+	s := New(1)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go s.Run(stopCh)
+
+	err := s.Set("default/foo", "@every 1s", []Invocation{{Request: &invoker.Request{URL: server.URL}, Client: http.DefaultClient}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case scheduledTime := <-scheduledTimes:
+		if scheduledTime == "" {
+			t.Errorf("Missing scheduled time")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("The entry has not been run")
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright (c) 2016-2017 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testutil holds helpers shared by the tests of the controller packages
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// NewApplyClientset returns a fake clientset holding the given objects and supporting the server-side apply of
+// cron jobs, see PrependApplyReactor
+func NewApplyClientset(objects ...runtime.Object) *fake.Clientset {
+	clientset := fake.NewSimpleClientset(objects...)
+	PrependApplyReactor(clientset)
+	return clientset
+}
+
+// PrependApplyReactor handles the server-side apply of cron jobs, which the fake object tracker doesn't support.
+// The applied configuration creates the cron job or is merged into it like a strategic merge patch. Like the API
+// server, the resource version is bumped when the cron job changes.
+// Field ownership is emulated per object rather than per field: a cron job updated without an apply belongs to
+// another manager, and an apply changing it is rejected with a conflict unless it is forced
+func PrependApplyReactor(clientset *fake.Clientset) {
+	version := 0
+	nextVersion := func() string {
+		version++
+		return strconv.Itoa(version)
+	}
+	clientset.PrependReactor("update", "cronjobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		cronJob, ok := action.(k8stesting.UpdateAction).GetObject().(*batchv1beta1.CronJob)
+		if ok && !hasManager(cronJob, updateManager) {
+			cronJob.ObjectMeta.ManagedFields = append(cronJob.ObjectMeta.ManagedFields, metav1.ManagedFieldsEntry{
+				Manager:   updateManager,
+				Operation: metav1.ManagedFieldsOperationUpdate,
+			})
+		}
+		// Stored by the object tracker
+		return false, nil, nil
+	})
+	clientset.PrependReactor("patch", "cronjobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(k8stesting.PatchAction)
+		if patchAction.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		options := getPatchOptions(action)
+		applied := []metav1.ManagedFieldsEntry{{Manager: options.FieldManager, Operation: metav1.ManagedFieldsOperationApply}}
+		gvr := batchv1beta1.SchemeGroupVersion.WithResource("cronjobs")
+		ns := patchAction.GetNamespace()
+		existing, err := clientset.Tracker().Get(gvr, ns, patchAction.GetName())
+		if k8sErrors.IsNotFound(err) {
+			cronJob := &batchv1beta1.CronJob{}
+			if err := json.Unmarshal(patchAction.GetPatch(), cronJob); err != nil {
+				return true, nil, err
+			}
+			cronJob.ObjectMeta.ResourceVersion = nextVersion()
+			cronJob.ObjectMeta.ManagedFields = applied
+			return true, cronJob, clientset.Tracker().Create(gvr, cronJob, ns)
+		}
+		if err != nil {
+			return true, nil, err
+		}
+		original, err := json.Marshal(existing)
+		if err != nil {
+			return true, nil, err
+		}
+		merged, err := strategicpatch.StrategicMergePatch(original, patchAction.GetPatch(), &batchv1beta1.CronJob{})
+		if err != nil {
+			return true, nil, err
+		}
+		cronJob := &batchv1beta1.CronJob{}
+		if err := json.Unmarshal(merged, cronJob); err != nil {
+			return true, nil, err
+		}
+		if equality.Semantic.DeepEqual(existing, cronJob) {
+			return true, cronJob, nil
+		}
+		if hasManager(cronJob, updateManager) && (options.Force == nil || !*options.Force) {
+			return true, nil, k8sErrors.NewApplyConflict([]metav1.StatusCause{{
+				Type:    metav1.CauseTypeFieldManagerConflict,
+				Message: fmt.Sprintf("conflict with %q", updateManager),
+			}}, "Apply failed with 1 conflict")
+		}
+		cronJob.ObjectMeta.ResourceVersion = nextVersion()
+		// The forced apply takes the ownership of the fields changed by the other managers
+		cronJob.ObjectMeta.ManagedFields = applied
+		return true, cronJob, clientset.Tracker().Update(gvr, cronJob, ns)
+	})
+}
+
+// Manager of the changes made to cron jobs without applying them
+const updateManager = "kubectl-edit"
+
+func hasManager(cronJob *batchv1beta1.CronJob, manager string) bool {
+	for _, entry := range cronJob.ObjectMeta.ManagedFields {
+		if entry.Manager == manager {
+			return true
+		}
+	}
+	return false
+}
+
+// getPatchOptions returns the options of the patch action. The fake clientsets of older client-go versions don't
+// record them, the apply is then considered forced
+func getPatchOptions(action k8stesting.Action) metav1.PatchOptions {
+	if withOptions, ok := action.(interface{ GetPatchOptions() metav1.PatchOptions }); ok {
+		return withOptions.GetPatchOptions()
+	}
+	force := true
+	return metav1.PatchOptions{Force: &force}
+}
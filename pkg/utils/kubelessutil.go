@@ -18,153 +18,898 @@ package utils
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/imdario/mergo"
 	cronjobTriggerApi "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/cronjob-trigger/pkg/invoker"
+	"github.com/kubeless/cronjob-trigger/pkg/scheduler"
 	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
 	batchv1 "k8s.io/api/batch/v1"
 	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	batchv1apply "k8s.io/client-go/applyconfigurations/batch/v1"
+	batchv1beta1apply "k8s.io/client-go/applyconfigurations/batch/v1beta1"
+	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
-// EnsureCronJob creates/updates a function cron job
-func EnsureCronJob(client kubernetes.Interface, funcObj *kubelessApi.Function, cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger, reqImage string, or []metav1.OwnerReference, reqImagePullSecret []v1.LocalObjectReference) error {
-	var maxSucccessfulHist, maxFailedHist int32
-	maxSucccessfulHist = 3
-	maxFailedHist = 1
-	var timeout int
-	if funcObj.Spec.Timeout != "" {
+// Path of the controller binary in its image, which also acts as the invoker of the generated Jobs
+const invokerCommand = "/cronjob-controller"
+
+const (
+	// DefaultLabelSelector selects the objects created by the controller
+	DefaultLabelSelector = "created-by=kubeless"
+	// TriggerLabel holds the name of the trigger on the jobs making its runs, shortened to fit in a label value
+	TriggerLabel = "cronjobtrigger.kubeless.io/trigger"
+	// TriggerAnnotation holds the full name of the trigger on the jobs making its runs
+	TriggerAnnotation = "cronjobtrigger.kubeless.io/trigger"
+	// ScheduledTimeAnnotation holds the scheduled time of the run made by the jobs the controller creates itself
+	ScheduledTimeAnnotation = "cronjobtrigger.kubeless.io/scheduled-time"
+)
+
+const (
+	// Port used when the target service doesn't expose any
+	defaultFunctionPort = 8080
+
+	// Paths where the certificates of the TLS settings are mounted in the invoker
+	tlsCAMountPath         = "/etc/kubeless/tls/ca"
+	tlsClientCertMountPath = "/etc/kubeless/tls/client"
+	tlsCAFile              = "ca.crt"
+
+	// Manager of the fields of the generated CronJobs, applied server-side
+	fieldManager = "cronjob-trigger-controller"
+
+	// Seconds after which the finished jobs created by the controller are deleted, once their runs are recorded
+	jobTTLSecondsAfterFinished = 24 * 60 * 60
+
+	// Longest name of a CronJob, whose jobs are named after it and the scheduled time in minutes
+	maxCronJobNameLength = 52
+	// Longest name of a job, which is used as the value of the job-name label of its pods
+	maxJobNameLength = validation.LabelValueMaxLength
+	// Length of the hash that keeps the shortened names unique
+	nameHashLength = 8
+)
+
+// GetURLScheme returns the scheme used to call services with the given TLS settings
+func GetURLScheme(tlsConfig *cronjobTriggerApi.TLSConfig) (string, error) {
+	if tlsConfig == nil {
+		return "http", nil
+	}
+	switch tlsConfig.Scheme {
+	case "", "https":
+		return "https", nil
+	case "http":
+		return "http", nil
+	default:
+		return "", fmt.Errorf("Unsupported scheme %s, expecting http or https", tlsConfig.Scheme)
+	}
+}
+
+// GetTarget returns the endpoint called by the trigger. The function-name field is a shorthand for a function target.
+// It returns nil if the trigger calls the functions matching its function selector instead.
+func GetTarget(spec *cronjobTriggerApi.CronJobTriggerSpec) (*cronjobTriggerApi.Target, error) {
+	if spec.FunctionSelector != nil {
+		if spec.FunctionName != "" || spec.Target != nil {
+			return nil, fmt.Errorf("functionSelector can't be specified together with function-name or target")
+		}
+		return nil, nil
+	}
+	if spec.Target == nil {
+		if spec.FunctionName == "" {
+			return nil, fmt.Errorf("One of function-name, functionSelector or target must be specified")
+		}
+		return &cronjobTriggerApi.Target{
+			Function: &cronjobTriggerApi.FunctionTarget{
+				Name: spec.FunctionName,
+				Port: spec.FunctionPort,
+			},
+		}, nil
+	}
+	if spec.FunctionName != "" {
+		return nil, fmt.Errorf("function-name and target can't be specified at the same time")
+	}
+	if err := ValidateTarget(spec.Target); err != nil {
+		return nil, err
+	}
+	return spec.Target, nil
+}
+
+// ValidateTarget checks that exactly one endpoint is set in the target
+func ValidateTarget(target *cronjobTriggerApi.Target) error {
+	count := 0
+	if target.Function != nil {
+		if target.Function.Name == "" {
+			return fmt.Errorf("The name of the target function must be specified")
+		}
+		count++
+	}
+	if target.Service != nil {
+		if target.Service.Name == "" {
+			return fmt.Errorf("The name of the target service must be specified")
+		}
+		count++
+	}
+	if target.URL != "" {
+		u, err := url.Parse(target.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("The target URL %s is not a valid HTTP URL", target.URL)
+		}
+		count++
+	}
+	if count != 1 {
+		return fmt.Errorf("Exactly one of function, service or url must be specified in the target")
+	}
+	return nil
+}
+
+// GetServiceEndpoint returns the in-cluster URL of the given service.
+// The port is selected by name or number, the first port of the service is used if none is given.
+func GetServiceEndpoint(svc *v1.Service, portName, path, scheme, clusterDomain string) (string, error) {
+	port := int32(defaultFunctionPort)
+	if portName != "" {
+		found := false
+		for _, p := range svc.Spec.Ports {
+			if p.Name == portName {
+				port = p.Port
+				found = true
+				break
+			}
+		}
+		if !found {
+			number, err := strconv.Atoi(portName)
+			if err != nil {
+				return "", fmt.Errorf("Service %s/%s has no port named %s", svc.ObjectMeta.Namespace, svc.ObjectMeta.Name, portName)
+			}
+			port = int32(number)
+		}
+	} else if len(svc.Spec.Ports) != 0 {
+		port = svc.Spec.Ports[0].Port
+	}
+	endpoint := fmt.Sprintf("%s://%s.%s.svc.%s:%d", scheme, svc.ObjectMeta.Name, svc.ObjectMeta.Namespace, clusterDomain, port)
+	if path != "" {
+		endpoint += "/" + strings.TrimPrefix(path, "/")
+	}
+	return endpoint, nil
+}
+
+// DeleteStaleCronJobs removes the cron jobs owned by the trigger that don't call any of the given functions,
+// and returns the names of the removed cron jobs
+func DeleteStaleCronJobs(client kubernetes.Interface, cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger, functions []*kubelessApi.Function) ([]string, error) {
+	if cronjobTriggerObj.ObjectMeta.UID == "" {
+		return nil, nil
+	}
+	expected := map[string]bool{}
+	for _, funcObj := range functions {
+		expected[GetCronJobName(funcObj, cronjobTriggerObj)] = true
+	}
+	cronJobs, err := client.BatchV1beta1().CronJobs(cronjobTriggerObj.ObjectMeta.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, cronJob := range cronJobs.Items {
+		if expected[cronJob.ObjectMeta.Name] || !isOwnedBy(cronJob.ObjectMeta, cronjobTriggerObj.ObjectMeta.UID) {
+			continue
+		}
+		err = client.BatchV1beta1().CronJobs(cronJob.ObjectMeta.Namespace).Delete(context.TODO(), cronJob.ObjectMeta.Name, metav1.DeleteOptions{})
+		if err != nil {
+			if k8sErrors.IsNotFound(err) {
+				continue
+			}
+			return deleted, err
+		}
+		deleted = append(deleted, cronJob.ObjectMeta.Name)
+	}
+	return deleted, nil
+}
+
+func isOwnedBy(objMeta metav1.ObjectMeta, uid types.UID) bool {
+	for _, owner := range objMeta.OwnerReferences {
+		if owner.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwnedByOtherTrigger returns true if the object is owned by a trigger, current or legacy, with another UID
+func isOwnedByOtherTrigger(objMeta metav1.ObjectMeta, uid types.UID) bool {
+	for _, owner := range objMeta.OwnerReferences {
+		if (owner.Kind == "CronJobTrigger" || owner.Kind == "Trigger") && owner.UID != uid {
+			return true
+		}
+	}
+	return false
+}
+
+// getTLSVolumes returns the volumes holding the certificates of the given TLS settings
+// along with the invoker settings pointing to their mount paths
+func getTLSVolumes(tlsConfig *cronjobTriggerApi.TLSConfig) (*invoker.TLSConfig, []v1.Volume, []v1.VolumeMount) {
+	if tlsConfig == nil {
+		return nil, nil, nil
+	}
+
+	invokerTLS := &invoker.TLSConfig{
+		ServerName: tlsConfig.ServerName,
+	}
+	volumes := []v1.Volume{}
+	mounts := []v1.VolumeMount{}
+	if tlsConfig.CABundle != nil {
+		volumes = append(volumes, v1.Volume{
+			Name: "ca-bundle",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: tlsConfig.CABundle.LocalObjectReference,
+					Items: []v1.KeyToPath{
+						{Key: tlsConfig.CABundle.Key, Path: tlsCAFile},
+					},
+				},
+			},
+		})
+		mounts = append(mounts, v1.VolumeMount{Name: "ca-bundle", MountPath: tlsCAMountPath, ReadOnly: true})
+		invokerTLS.CAFile = filepath.Join(tlsCAMountPath, tlsCAFile)
+	}
+	if tlsConfig.ClientCertSecret != "" {
+		volumes = append(volumes, v1.Volume{
+			Name: "client-cert",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: tlsConfig.ClientCertSecret,
+				},
+			},
+		})
+		mounts = append(mounts, v1.VolumeMount{Name: "client-cert", MountPath: tlsClientCertMountPath, ReadOnly: true})
+		invokerTLS.CertFile = filepath.Join(tlsClientCertMountPath, v1.TLSCertKey)
+		invokerTLS.KeyFile = filepath.Join(tlsClientCertMountPath, v1.TLSPrivateKeyKey)
+	}
+	return invokerTLS, volumes, mounts
+}
+
+// GetSchedule returns the schedule of the trigger with its H values expanded.
+// The values are derived from the trigger UID so every trigger keeps the same schedule.
+func GetSchedule(cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger) (string, error) {
+	seed := string(cronjobTriggerObj.ObjectMeta.UID)
+	if seed == "" {
+		seed = cronjobTriggerObj.ObjectMeta.Namespace + "/" + cronjobTriggerObj.ObjectMeta.Name
+	}
+	return scheduler.ExpandHashes(cronjobTriggerObj.Spec.Schedule, seed)
+}
+
+// GetInvocationRequest returns the request sent to the endpoint on every run of the trigger, without TLS settings.
+// funcObj is the target function of the trigger, nil when the trigger doesn't call a Kubeless function.
+func GetInvocationRequest(funcObj *kubelessApi.Function, cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger, endpoint string) (*invoker.Request, error) {
+	timeout, _ := strconv.Atoi(defaultTimeout)
+	if funcObj != nil && funcObj.Spec.Timeout != "" {
 		var err error
 		timeout, err = strconv.Atoi(funcObj.Spec.Timeout)
 		if err != nil {
-			return fmt.Errorf("Unable convert %s to a valid timeout", funcObj.Spec.Timeout)
+			return nil, fmt.Errorf("Unable convert %s to a valid timeout", funcObj.Spec.Timeout)
 		}
-	} else {
-		timeout, _ = strconv.Atoi(defaultTimeout)
 	}
 
-	schedule := cronjobTriggerObj.Spec.Schedule
 	rawPayload, err := json.Marshal(cronjobTriggerObj.Spec.Payload)
-	payload := string(rawPayload)
-	payloadContentType := "application/json"
+	if err != nil {
+		return nil, fmt.Errorf("Found an error during JSON parsing on your payload: %s", err)
+	}
 
+	exclusions, err := getExclusions(cronjobTriggerObj.Spec.Exclusions)
+	if err != nil {
+		return nil, err
+	}
+	onSuccess, err := getFollowUps(cronjobTriggerObj.Spec.OnSuccess)
 	if err != nil {
-		return fmt.Errorf("Found an error during JSON parsing on your payload: %s", err)
+		return nil, err
+	}
+	onFailure, err := getFollowUps(cronjobTriggerObj.Spec.OnFailure)
+	if err != nil {
+		return nil, err
 	}
 
-	activeDeadlineSeconds := int64(timeout)
-	jobName := fmt.Sprintf("trigger-%s", funcObj.ObjectMeta.Name)
-	functionPort := "8080"
-	if len(funcObj.Spec.ServiceSpec.Ports) != 0 {
-		functionPort = strconv.Itoa(int(funcObj.Spec.ServiceSpec.Ports[0].Port))
+	request := &invoker.Request{
+		URL:            endpoint,
+		ContentType:    "application/json",
+		TimeoutSeconds: timeout,
+		TriggerUID:     string(cronjobTriggerObj.ObjectMeta.UID),
+		Retry:          cronjobTriggerObj.Spec.Retry,
+		Jitter:         cronjobTriggerObj.Spec.Jitter,
+		Exclusions:     exclusions,
+		OnSuccess:      onSuccess,
+		OnFailure:      onFailure,
 	}
+	if payload := string(rawPayload); payload != "null" {
+		request.Payload = payload
+	}
+	return request, nil
+}
 
-	functionEndpoint := fmt.Sprintf("http://%s.%s.svc.cluster.local:%s", funcObj.ObjectMeta.Name, funcObj.ObjectMeta.Namespace, functionPort)
+// getExclusions returns the time ranges of the exclusions, which must not reference calendars anymore
+func getExclusions(exclusions []cronjobTriggerApi.Exclusion) ([]invoker.Exclusion, error) {
+	result := []invoker.Exclusion{}
+	for _, exclusion := range exclusions {
+		if exclusion.Calendar != "" {
+			return nil, fmt.Errorf("The days of the calendar %s have not been resolved", exclusion.Calendar)
+		}
+		if exclusion.Start == nil || exclusion.End == nil {
+			return nil, fmt.Errorf("Exclusions require either a calendar or a start and an end")
+		}
+		if !exclusion.End.After(exclusion.Start.Time) {
+			return nil, fmt.Errorf("The end of an exclusion must be after its start")
+		}
+		reason := exclusion.Name
+		if reason == "" {
+			reason = fmt.Sprintf("Excluded from %s to %s", exclusion.Start.UTC().Format(time.RFC3339), exclusion.End.UTC().Format(time.RFC3339))
+		}
+		result = append(result, invoker.Exclusion{Start: exclusion.Start.UTC(), End: exclusion.End.UTC(), Reason: reason})
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
 
-	headersTemplate := "-H %s -H %s -H %s -H %s -H %s"
-	eventId := "\"Event-Id: $(POD_UID)\""
-	eventTime := "\"Event-Time: $(date --rfc-3339=seconds --utc)\""
-	eventNamespace := "\"Event-Namespace: cronjobtrigger.kubeless.io\""
-	eventType := fmt.Sprintf("\"Event-Type: %s\"", payloadContentType)
-	contentType := fmt.Sprintf("\"Content-Type: %s\"", payloadContentType)
-	headers := fmt.Sprintf(headersTemplate, eventId, eventTime, eventNamespace, eventType, contentType)
+// getFollowUps returns the calls of the follow-ups, which must only have URL targets once resolved
+func getFollowUps(followUps []cronjobTriggerApi.FollowUp) ([]invoker.FollowUp, error) {
+	var result []invoker.FollowUp
+	for _, followUp := range followUps {
+		if err := ValidateTarget(&followUp.Target); err != nil {
+			return nil, fmt.Errorf("Invalid follow-up: %v", err)
+		}
+		if followUp.Target.URL == "" {
+			return nil, fmt.Errorf("The endpoints of the follow-ups have not been resolved")
+		}
+		result = append(result, invoker.FollowUp{URL: followUp.Target.URL, PassResponse: followUp.PassResponse})
+	}
+	return result, nil
+}
 
-	commandTemplate := "curl -Lv %s %s"
-	command := fmt.Sprintf(commandTemplate, headers, functionEndpoint)
+// GetCalendarExclusions returns an exclusion for every day of the calendar.
+// name is the description of the exclusions, the name of the calendar by default.
+func GetCalendarExclusions(calendar *cronjobTriggerApi.TriggerCalendar, name string) ([]cronjobTriggerApi.Exclusion, error) {
+	location, err := time.LoadLocation(calendar.Spec.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid time zone %q in the calendar %s: %v", calendar.Spec.TimeZone, calendar.ObjectMeta.Name, err)
+	}
+	if name == "" {
+		name = fmt.Sprintf("Excluded by the calendar %s", calendar.ObjectMeta.Name)
+	}
+	exclusions := []cronjobTriggerApi.Exclusion{}
+	for _, date := range calendar.Spec.Dates {
+		day, err := time.ParseInLocation(cronjobTriggerApi.TriggerCalendarDateFormat, date, location)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid date %q in the calendar %s: %v", date, calendar.ObjectMeta.Name, err)
+		}
+		start := metav1.NewTime(day)
+		end := metav1.NewTime(day.AddDate(0, 0, 1))
+		exclusions = append(exclusions, cronjobTriggerApi.Exclusion{Name: name, Start: &start, End: &end})
+	}
+	return exclusions, nil
+}
+
+// GetHTTPClient returns a client for the TLS settings of a trigger, reading its certificates from the cluster
+func GetHTTPClient(client kubernetes.Interface, namespace string, tlsConfig *cronjobTriggerApi.TLSConfig) (*http.Client, error) {
+	if tlsConfig == nil {
+		return http.DefaultClient, nil
+	}
+
+	var caBundle, cert, key []byte
+	if tlsConfig.CABundle != nil {
+		configMap, err := client.CoreV1().ConfigMaps(namespace).Get(context.TODO(), tlsConfig.CABundle.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		data, ok := configMap.Data[tlsConfig.CABundle.Key]
+		if !ok {
+			return nil, fmt.Errorf("ConfigMap %s/%s has no key %s", namespace, tlsConfig.CABundle.Name, tlsConfig.CABundle.Key)
+		}
+		caBundle = []byte(data)
+	}
+	if tlsConfig.ClientCertSecret != "" {
+		secret, err := client.CoreV1().Secrets(namespace).Get(context.TODO(), tlsConfig.ClientCertSecret, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		cert = secret.Data[v1.TLSCertKey]
+		key = secret.Data[v1.TLSPrivateKeyKey]
+	}
+	return invoker.NewClientFromPEM(caBundle, cert, key, tlsConfig.ServerName)
+}
+
+// OperationResult tells what EnsureCronJob did to the cron job
+type OperationResult string
 
-	mergedLabels := mergeMaps(cronjobTriggerObj.ObjectMeta.Labels, funcObj.ObjectMeta.Labels)
-	mergedAnnotations := mergeMaps(cronjobTriggerObj.ObjectMeta.Annotations, funcObj.ObjectMeta.Annotations)
+const (
+	// OperationResultNone means the cron job was already up to date
+	OperationResultNone OperationResult = "unchanged"
+	// OperationResultCreated means the cron job has been created
+	OperationResultCreated OperationResult = "created"
+	// OperationResultUpdated means the cron job has been updated
+	OperationResultUpdated OperationResult = "updated"
+)
+
+// ConflictError is returned when a cron job with the name of the one of a trigger has not been created by the controller,
+// or when the fields the controller applies to it are managed by someone else
+type ConflictError struct {
+	Namespace string
+	Name      string
+	// Error of the rejected apply, nil when the cron job has not been created by the controller
+	Err error
+}
 
-	if payload != "null" {
-		command += fmt.Sprintf(" -d '%s'", payload)
+func (e *ConflictError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Fields of the cronjob object %s/%s have been changed outside of the trigger, revert them or delete the cronjob: %v", e.Namespace, e.Name, e.Err)
+	}
+	return fmt.Sprintf("Found a conflicting cronjob object %s/%s. Aborting", e.Namespace, e.Name)
+}
+
+// IsConflict returns true if the error is a ConflictError
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// EnsureCronJob creates/updates a cron job calling the given endpoint, and returns it along with what has been done.
+// The cron job is applied server-side, so that only the fields set by the controller are enforced.
+// funcObj is the target function of the trigger, nil when the trigger doesn't call a Kubeless function.
+func EnsureCronJob(client kubernetes.Interface, funcObj *kubelessApi.Function, cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger, endpoint, reqImage string, or []metav1.OwnerReference, reqImagePullSecret []v1.LocalObjectReference) (*batchv1beta1.CronJob, OperationResult, error) {
+	var maxSucccessfulHist, maxFailedHist int32
+	maxSucccessfulHist = 3
+	maxFailedHist = 1
+	name := cronjobTriggerObj.ObjectMeta.Name
+	namespace := cronjobTriggerObj.ObjectMeta.Namespace
+	var funcLabels, funcAnnotations map[string]string
+	if funcObj != nil {
+		name = funcObj.ObjectMeta.Name
+		namespace = funcObj.ObjectMeta.Namespace
+		funcLabels = funcObj.ObjectMeta.Labels
+		funcAnnotations = funcObj.ObjectMeta.Annotations
 	}
 
-	job := &batchv1beta1.CronJob{
+	schedule, err := GetSchedule(cronjobTriggerObj)
+	if err != nil {
+		return nil, OperationResultNone, err
+	}
+
+	jobName := GetCronJobName(funcObj, cronjobTriggerObj)
+
+	request, err := GetInvocationRequest(funcObj, cronjobTriggerObj, endpoint)
+	if err != nil {
+		return nil, OperationResultNone, err
+	}
+
+	mergedLabels := mergeMaps(cronjobTriggerObj.ObjectMeta.Labels, funcLabels)
+	mergedAnnotations := mergeMaps(cronjobTriggerObj.ObjectMeta.Annotations, funcAnnotations)
+	delete(mergedAnnotations, cronjobTriggerApi.RunNowAnnotation)
+
+	jobSpec, err := getJobSpec(request, cronjobTriggerObj, reqImage, reqImagePullSecret, addDefaultLabel(mergedLabels), mergedAnnotations)
+	if err != nil {
+		return nil, OperationResultNone, err
+	}
+
+	jobSpecConfig, err := jobSpecApplyConfiguration(jobSpec)
+	if err != nil {
+		return nil, OperationResultNone, err
+	}
+	ownerConfigs := make([]*metav1apply.OwnerReferenceApplyConfiguration, 0, len(or))
+	for _, owner := range or {
+		ownerConfig := metav1apply.OwnerReference().
+			WithAPIVersion(owner.APIVersion).
+			WithKind(owner.Kind).
+			WithName(owner.Name).
+			WithUID(owner.UID)
+		if owner.Controller != nil {
+			ownerConfig.WithController(*owner.Controller)
+		}
+		if owner.BlockOwnerDeletion != nil {
+			ownerConfig.WithBlockOwnerDeletion(*owner.BlockOwnerDeletion)
+		}
+		ownerConfigs = append(ownerConfigs, ownerConfig)
+	}
+	job := batchv1beta1apply.CronJob(jobName, namespace).
+		WithLabels(addDefaultLabel(mergedLabels)).
+		WithAnnotations(mergedAnnotations).
+		WithOwnerReferences(ownerConfigs...).
+		WithSpec(batchv1beta1apply.CronJobSpec().
+			WithSchedule(schedule).
+			WithSuccessfulJobsHistoryLimit(maxSucccessfulHist).
+			WithFailedJobsHistoryLimit(maxFailedHist).
+			WithJobTemplate(batchv1beta1apply.JobTemplateSpec().
+				// The jobs carry the default label, like the ones created by the controller
+				WithLabels(getJobLabels(cronjobTriggerObj, addDefaultLabel(mergedLabels))).
+				WithAnnotations(getJobAnnotations(cronjobTriggerObj, nil)).
+				WithSpec(jobSpecConfig)))
+
+	cronJob, err := client.BatchV1beta1().CronJobs(namespace).Get(context.TODO(), jobName, metav1.GetOptions{})
+	switch {
+	case k8sErrors.IsNotFound(err):
+		cronJob = nil
+	case err != nil:
+		return nil, OperationResultNone, err
+	case !hasDefaultLabel(cronJob.ObjectMeta.Labels), isOwnedByOtherTrigger(cronJob.ObjectMeta, cronjobTriggerObj.ObjectMeta.UID):
+		return nil, OperationResultNone, &ConflictError{Namespace: namespace, Name: name}
+	}
+	applied, err := client.BatchV1beta1().CronJobs(namespace).Apply(context.TODO(), job, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		// The controller owns the fields it applies: the changes made to them outside of the trigger, like a
+		// kubectl edit, are reverted and reported as drifts rather than failing with a conflict
+		Force: true,
+	})
+	if k8sErrors.IsConflict(err) {
+		return nil, OperationResultNone, &ConflictError{Namespace: namespace, Name: jobName, Err: err}
+	}
+	if err != nil {
+		return nil, OperationResultNone, err
+	}
+	switch {
+	case cronJob == nil:
+		return applied, OperationResultCreated, nil
+	case cronJobChanged(cronJob, applied):
+		return applied, OperationResultUpdated, nil
+	default:
+		return applied, OperationResultNone, nil
+	}
+}
+
+// jobSpecApplyConfiguration converts the spec shared with the jobs created directly to its apply configuration,
+// which has the same serialized form
+func jobSpecApplyConfiguration(spec batchv1.JobSpec) (*batchv1apply.JobSpecApplyConfiguration, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	config := &batchv1apply.JobSpecApplyConfiguration{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// cronJobChanged returns true if the spec or the metadata set by the controller differ between the cron jobs
+func cronJobChanged(oldObj, newObj *batchv1beta1.CronJob) bool {
+	return !equality.Semantic.DeepEqual(oldObj.Spec, newObj.Spec) ||
+		!equality.Semantic.DeepEqual(oldObj.ObjectMeta.Labels, newObj.ObjectMeta.Labels) ||
+		!equality.Semantic.DeepEqual(oldObj.ObjectMeta.Annotations, newObj.ObjectMeta.Annotations) ||
+		!equality.Semantic.DeepEqual(oldObj.ObjectMeta.OwnerReferences, newObj.ObjectMeta.OwnerReferences)
+}
+
+// EnsureJob creates the job making the single run of a trigger with runAt, and returns it.
+// The job is not updated if it already exists, since it may be running.
+// funcObj is the target function of the trigger, nil when the trigger doesn't call a Kubeless function.
+func EnsureJob(client kubernetes.Interface, funcObj *kubelessApi.Function, cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger, endpoint, reqImage string, or []metav1.OwnerReference, reqImagePullSecret []v1.LocalObjectReference) (*batchv1.Job, error) {
+	if cronjobTriggerObj.Spec.RunAt == nil {
+		return nil, fmt.Errorf("CronJob trigger %s/%s has no runAt time", cronjobTriggerObj.ObjectMeta.Namespace, cronjobTriggerObj.ObjectMeta.Name)
+	}
+	runAt := cronjobTriggerObj.Spec.RunAt.Time.UTC()
+	// Named like the jobs of a CronJob, after the scheduled time in minutes
+	jobName := fmt.Sprintf("%s-%d", GetCronJobName(funcObj, cronjobTriggerObj), runAt.Unix()/60)
+	return ensureJob(client, funcObj, cronjobTriggerObj, endpoint, reqImage, or, reqImagePullSecret, jobName, runAt, true)
+}
+
+// EnsureCatchUpJob creates the job making a run missed at the given scheduled time, and returns it.
+// The job is named after the scheduled time in seconds, so a run is never caught up twice.
+// funcObj is the target function of the trigger, nil when the trigger doesn't call a Kubeless function.
+func EnsureCatchUpJob(client kubernetes.Interface, funcObj *kubelessApi.Function, cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger, endpoint, reqImage string, or []metav1.OwnerReference, reqImagePullSecret []v1.LocalObjectReference, scheduledTime time.Time) (*batchv1.Job, error) {
+	scheduledTime = scheduledTime.UTC()
+	jobName := getJobName(GetCronJobName(funcObj, cronjobTriggerObj), strconv.FormatInt(scheduledTime.Unix(), 10))
+	return ensureJob(client, funcObj, cronjobTriggerObj, endpoint, reqImage, or, reqImagePullSecret, jobName, scheduledTime, true)
+}
+
+// JobScheduledTime returns the scheduled time of the run made by the job, false for the jobs of runs requested
+// with the run-now annotation
+func JobScheduledTime(job *batchv1.Job) (time.Time, bool) {
+	if value, ok := job.ObjectMeta.Annotations[ScheduledTimeAnnotation]; ok {
+		scheduledTime, err := time.Parse(time.RFC3339, value)
+		return scheduledTime, err == nil
+	}
+	for _, owner := range job.ObjectMeta.OwnerReferences {
+		if owner.Kind == "CronJob" {
+			scheduledTime, err := invoker.ScheduledTimeFromJobName(job.ObjectMeta.Name)
+			return scheduledTime, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// EnsureManualJob creates the job making the run requested with the given run-now token, and returns it.
+// funcObj is the target function of the trigger, nil when the trigger doesn't call a Kubeless function.
+func EnsureManualJob(client kubernetes.Interface, funcObj *kubelessApi.Function, cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger, endpoint, reqImage string, or []metav1.OwnerReference, reqImagePullSecret []v1.LocalObjectReference, token string) (*batchv1.Job, error) {
+	// Tokens may not be valid in names, the job is named after their hash so a token never creates two jobs
+	sum := sha256.Sum256([]byte(token))
+	jobName := getJobName(GetCronJobName(funcObj, cronjobTriggerObj), "manual-"+hex.EncodeToString(sum[:])[:10])
+	return ensureJob(client, funcObj, cronjobTriggerObj, endpoint, reqImage, or, reqImagePullSecret, jobName, time.Now().UTC().Truncate(time.Second), false)
+}
+
+func ensureJob(client kubernetes.Interface, funcObj *kubelessApi.Function, cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger, endpoint, reqImage string, or []metav1.OwnerReference, reqImagePullSecret []v1.LocalObjectReference, jobName string, scheduledTime time.Time, scheduled bool) (*batchv1.Job, error) {
+	namespace := cronjobTriggerObj.ObjectMeta.Namespace
+	var funcLabels, funcAnnotations map[string]string
+	if funcObj != nil {
+		namespace = funcObj.ObjectMeta.Namespace
+		funcLabels = funcObj.ObjectMeta.Labels
+		funcAnnotations = funcObj.ObjectMeta.Annotations
+	}
+
+	request, err := GetInvocationRequest(funcObj, cronjobTriggerObj, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	request.ScheduledTime = &scheduledTime
+
+	mergedLabels := addDefaultLabel(mergeMaps(cronjobTriggerObj.ObjectMeta.Labels, funcLabels))
+	mergedAnnotations := mergeMaps(cronjobTriggerObj.ObjectMeta.Annotations, funcAnnotations)
+	delete(mergedAnnotations, cronjobTriggerApi.RunNowAnnotation)
+
+	jobSpec, err := getJobSpec(request, cronjobTriggerObj, reqImage, reqImagePullSecret, mergedLabels, mergedAnnotations)
+	if err != nil {
+		return nil, err
+	}
+	// Unlike the jobs of the CronJobs, these ones aren't removed by a history limit
+	ttl := int32(jobTTLSecondsAfterFinished)
+	jobSpec.TTLSecondsAfterFinished = &ttl
+	jobAnnotations := getJobAnnotations(cronjobTriggerObj, mergedAnnotations)
+	if scheduled {
+		jobAnnotations[ScheduledTimeAnnotation] = scheduledTime.Format(time.RFC3339)
+	}
+
+	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            jobName,
-			Namespace:       funcObj.ObjectMeta.Namespace,
-			Labels:          addDefaultLabel(mergedLabels),
-			Annotations:     mergedAnnotations,
+			Namespace:       namespace,
+			Labels:          getJobLabels(cronjobTriggerObj, mergedLabels),
+			Annotations:     jobAnnotations,
 			OwnerReferences: or,
 		},
-		Spec: batchv1beta1.CronJobSpec{
-			Schedule:                   schedule,
-			SuccessfulJobsHistoryLimit: &maxSucccessfulHist,
-			FailedJobsHistoryLimit:     &maxFailedHist,
-			JobTemplate: batchv1beta1.JobTemplateSpec{
-				Spec: batchv1.JobSpec{
-					ActiveDeadlineSeconds: &activeDeadlineSeconds,
-					Template: v1.PodTemplateSpec{
-						ObjectMeta: metav1.ObjectMeta{
-							Labels:      addDefaultLabel(mergedLabels),
-							Annotations: mergedAnnotations,
-						},
-						Spec: v1.PodSpec{
-							ImagePullSecrets: reqImagePullSecret,
-							Containers: []v1.Container{
-								{
-									Image: reqImage,
-									Name:  "trigger",
-									Env: []v1.EnvVar{
-										{
-											Name: "POD_UID",
-											ValueFrom: &v1.EnvVarSource{
-												FieldRef: &v1.ObjectFieldSelector{
-													FieldPath: "metadata.uid",
-												},
-											},
-										},
-									},
-									Command: []string{
-										"/bin/sh",
-										"-c",
-									},
-									Args: []string{
-										command,
-									},
-									Resources: v1.ResourceRequirements{
-										Limits: v1.ResourceList{
-											v1.ResourceMemory: resource.MustParse("64Mi"),
-											v1.ResourceCPU:    resource.MustParse("100m"),
-										},
-										Requests: v1.ResourceList{
-											v1.ResourceMemory: resource.MustParse("16Mi"),
-											v1.ResourceCPU:    resource.MustParse("10m"),
-										},
+		Spec: jobSpec,
+	}
+
+	created, err := client.BatchV1().Jobs(namespace).Create(context.TODO(), job, metav1.CreateOptions{})
+	if err != nil && k8sErrors.IsAlreadyExists(err) {
+		existing, err := client.BatchV1().Jobs(namespace).Get(context.TODO(), jobName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if !hasDefaultLabel(existing.ObjectMeta.Labels) {
+			return nil, fmt.Errorf("Found a conflicting job object %s/%s. Aborting", namespace, jobName)
+		}
+		return existing, nil
+	}
+	return created, err
+}
+
+// GetJobResult returns the result the invoker wrote to the termination message of the latest pod of the job,
+// nil if no pod terminated with a result
+func GetJobResult(client kubernetes.Interface, job *batchv1.Job) (*invoker.Result, error) {
+	pods, err := client.CoreV1().Pods(job.ObjectMeta.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "job-name=" + job.ObjectMeta.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result *invoker.Result
+	var finishedAt time.Time
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			terminated := status.State.Terminated
+			if terminated == nil || terminated.Message == "" || terminated.FinishedAt.Time.Before(finishedAt) {
+				continue
+			}
+			podResult := &invoker.Result{}
+			if err := json.Unmarshal([]byte(terminated.Message), podResult); err != nil {
+				// The message is the end of the logs when the invoker fails before writing its result
+				continue
+			}
+			result = podResult
+			finishedAt = terminated.FinishedAt.Time
+		}
+	}
+	return result, nil
+}
+
+// JobFinished returns whether the job is finished and, if so, whether it succeeded
+func JobFinished(job *batchv1.Job) (bool, bool) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != v1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, true
+		case batchv1.JobFailed:
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// GetCronJobName returns the name of the cron job calling the given function, nil when the trigger doesn't call a Kubeless function.
+// The cron jobs of function targets are named after the function, the other ones after the trigger and a hash of its
+// UID, so they can't take the name of the cron job of a function. Long names are shortened to fit in the name of a CronJob
+func GetCronJobName(funcObj *kubelessApi.Function, cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger) string {
+	if funcObj != nil && cronjobTriggerObj.Spec.FunctionSelector == nil {
+		return shortenName(fmt.Sprintf("trigger-%s", funcObj.ObjectMeta.Name), maxCronJobNameLength)
+	}
+	sum := sha256.Sum256([]byte(cronjobTriggerObj.ObjectMeta.UID))
+	name := fmt.Sprintf("trigger-%s-%s", cronjobTriggerObj.ObjectMeta.Name, hex.EncodeToString(sum[:])[:nameHashLength])
+	if funcObj != nil {
+		// A trigger with a function selector owns one cron job per selected function
+		name += "-" + funcObj.ObjectMeta.Name
+	}
+	return shortenName(name, maxCronJobNameLength)
+}
+
+// getJobName returns the name of a job created for the cron job, the name of the cron job being shortened
+// so that the suffix always fits
+func getJobName(cronJobName, suffix string) string {
+	return shortenName(cronJobName, maxJobNameLength-len(suffix)-1) + "-" + suffix
+}
+
+// shortenName returns the name unchanged if it fits in the given length, otherwise its beginning followed by a hash
+// of the whole name, so that two long names sharing the same beginning still differ
+func shortenName(name string, maxLength int) string {
+	if len(name) <= maxLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	prefix := strings.TrimRight(name[:maxLength-nameHashLength-1], "-.")
+	return prefix + "-" + hex.EncodeToString(sum[:])[:nameHashLength]
+}
+
+// getJobSpec returns the spec of the jobs running the invoker for the given request
+func getJobSpec(request *invoker.Request, cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger, reqImage string, reqImagePullSecret []v1.LocalObjectReference, labels, annotations map[string]string) (batchv1.JobSpec, error) {
+	invokerTLS, volumes, volumeMounts := getTLSVolumes(cronjobTriggerObj.Spec.TLS)
+	request.TLS = invokerTLS
+	rawRequest, err := json.Marshal(request)
+	if err != nil {
+		return batchv1.JobSpec{}, fmt.Errorf("Unable to serialize the invocation request: %s", err)
+	}
+
+	// Retries happen inside the invoker, the Job itself should run a single pod
+	var backoffLimit int32
+	activeDeadlineSeconds := int64(request.Deadline().Seconds())
+
+	jobSpec := batchv1.JobSpec{
+		BackoffLimit:          &backoffLimit,
+		ActiveDeadlineSeconds: &activeDeadlineSeconds,
+		Template: v1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels:      labels,
+				Annotations: annotations,
+			},
+			Spec: v1.PodSpec{
+				ImagePullSecrets: reqImagePullSecret,
+				Volumes:          volumes,
+				Containers: []v1.Container{
+					{
+						Image: reqImage,
+						Name:  "trigger",
+						Env: []v1.EnvVar{
+							{
+								Name: "JOB_NAME",
+								ValueFrom: &v1.EnvVarSource{
+									FieldRef: &v1.ObjectFieldSelector{
+										FieldPath: "metadata.labels['job-name']",
 									},
 								},
 							},
-							RestartPolicy: v1.RestartPolicyNever,
+							{
+								Name:  invoker.RequestEnvVar,
+								Value: string(rawRequest),
+							},
+						},
+						Command: []string{
+							invokerCommand,
+						},
+						Args: []string{
+							"invoke",
+						},
+						VolumeMounts:           volumeMounts,
+						TerminationMessagePath: invoker.TerminationMessagePath,
+						Resources: v1.ResourceRequirements{
+							Limits: v1.ResourceList{
+								v1.ResourceMemory: resource.MustParse("64Mi"),
+								v1.ResourceCPU:    resource.MustParse("100m"),
+							},
+							Requests: v1.ResourceList{
+								v1.ResourceMemory: resource.MustParse("16Mi"),
+								v1.ResourceCPU:    resource.MustParse("10m"),
+							},
 						},
 					},
 				},
+				RestartPolicy: v1.RestartPolicyNever,
 			},
 		},
 	}
+	applyJobTemplate(&jobSpec.Template, cronjobTriggerObj.Spec.JobTemplate)
+	return jobSpec, nil
+}
 
-	_, err = client.BatchV1beta1().CronJobs(funcObj.ObjectMeta.Namespace).Create(context.TODO(), job, metav1.CreateOptions{})
-	if err != nil && k8sErrors.IsAlreadyExists(err) {
-		newCronJob := &batchv1beta1.CronJob{}
-		newCronJob, err = client.BatchV1beta1().CronJobs(funcObj.ObjectMeta.Namespace).Get(context.TODO(), jobName, metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
-		if !hasDefaultLabel(newCronJob.ObjectMeta.Labels) {
-			return fmt.Errorf("Found a conflicting cronjob object %s/%s. Aborting", funcObj.ObjectMeta.Namespace, funcObj.ObjectMeta.Name)
-		}
-		newCronJob.ObjectMeta.Labels = funcObj.ObjectMeta.Labels
-		newCronJob.ObjectMeta.OwnerReferences = or
-		newCronJob.Spec = job.Spec
-		_, err = client.BatchV1beta1().CronJobs(funcObj.ObjectMeta.Namespace).Update(context.TODO(), newCronJob, metav1.UpdateOptions{})
+// applyJobTemplate sets the overrides of the template on the pods of a job
+func applyJobTemplate(pod *v1.PodTemplateSpec, template *cronjobTriggerApi.JobTemplate) {
+	if template == nil {
+		return
+	}
+	template = template.DeepCopy()
+	// The labels set by the controller identify the pods, they take precedence
+	pod.ObjectMeta.Labels = mergeMaps(pod.ObjectMeta.Labels, template.Labels)
+	// The resources are replaced as a whole, merging them could leave requests above the default limits
+	if template.Resources != nil {
+		pod.Spec.Containers[0].Resources = *template.Resources
+	}
+	pod.Spec.NodeSelector = template.NodeSelector
+	pod.Spec.Tolerations = template.Tolerations
+	pod.Spec.ServiceAccountName = template.ServiceAccountName
+	pod.Spec.PriorityClassName = template.PriorityClassName
+	pod.Spec.SecurityContext = template.SecurityContext
+}
+
+// MergeJobTemplates returns the job template made of the defaults replaced by the fields set in the overrides,
+// the labels of both are merged. Either may be nil
+func MergeJobTemplates(defaults, overrides *cronjobTriggerApi.JobTemplate) *cronjobTriggerApi.JobTemplate {
+	if overrides == nil {
+		return defaults.DeepCopy()
+	}
+	if defaults == nil {
+		return overrides.DeepCopy()
+	}
+	merged := defaults.DeepCopy()
+	overrides = overrides.DeepCopy()
+	if overrides.Labels != nil {
+		merged.Labels = mergeMaps(overrides.Labels, merged.Labels)
+	}
+	if overrides.Resources != nil {
+		merged.Resources = overrides.Resources
+	}
+	if overrides.NodeSelector != nil {
+		merged.NodeSelector = overrides.NodeSelector
+	}
+	if overrides.Tolerations != nil {
+		merged.Tolerations = overrides.Tolerations
+	}
+	if overrides.ServiceAccountName != "" {
+		merged.ServiceAccountName = overrides.ServiceAccountName
+	}
+	if overrides.PriorityClassName != "" {
+		merged.PriorityClassName = overrides.PriorityClassName
+	}
+	if overrides.SecurityContext != nil {
+		merged.SecurityContext = overrides.SecurityContext
+	}
+	return merged
+}
+
+// getJobLabels returns the labels of the jobs making the runs of the trigger, attributing them to the trigger
+func getJobLabels(cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger, labels map[string]string) map[string]string {
+	return mergeMaps(map[string]string{TriggerLabel: shortenName(cronjobTriggerObj.ObjectMeta.Name, validation.LabelValueMaxLength)}, labels)
+}
+
+// getJobAnnotations returns the annotations of the jobs making the runs of the trigger, holding its full name
+func getJobAnnotations(cronjobTriggerObj *cronjobTriggerApi.CronJobTrigger, annotations map[string]string) map[string]string {
+	return mergeMaps(map[string]string{TriggerAnnotation: cronjobTriggerObj.ObjectMeta.Name}, annotations)
+}
+
+// GetJobTriggerName returns the name of the trigger the job, or the template of the jobs of a cron job, makes the
+// runs of. The jobs created before the annotation only have the label
+func GetJobTriggerName(objMeta metav1.ObjectMeta) string {
+	if name, ok := objMeta.Annotations[TriggerAnnotation]; ok {
+		return name
 	}
-	return err
+	return objMeta.Labels[TriggerLabel]
 }
 
 func addDefaultLabel(labels map[string]string) map[string]string {
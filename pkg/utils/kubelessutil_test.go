@@ -1,19 +1,36 @@
 package utils
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	cronjobTriggerApi "github.com/kubeless/cronjob-trigger/pkg/apis/kubeless/v1beta1"
+	"github.com/kubeless/cronjob-trigger/pkg/invoker"
+	"github.com/kubeless/cronjob-trigger/pkg/testutil"
 	kubelessApi "github.com/kubeless/kubeless/pkg/apis/kubeless/v1beta1"
 
+	batchv1 "k8s.io/api/batch/v1"
 	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
 )
 
 func TestEnsureCronJob(t *testing.T) {
@@ -73,6 +90,7 @@ func TestEnsureCronJob(t *testing.T) {
 	}
 	cronjobTriggerObj := &cronjobTriggerApi.CronJobTrigger{
 		ObjectMeta: metav1.ObjectMeta{
+			UID: "trigger-uid",
 			Labels: map[string]string{
 				"test": "false",
 			},
@@ -88,7 +106,9 @@ func TestEnsureCronJob(t *testing.T) {
 	expectedMeta := metav1.ObjectMeta{
 		Name:            "trigger-" + f1Name,
 		Namespace:       ns,
+		ResourceVersion: "1",
 		OwnerReferences: or,
+		ManagedFields:   []metav1.ManagedFieldsEntry{{Manager: fieldManager, Operation: metav1.ManagedFieldsOperationApply}},
 		Labels: map[string]string{
 			"test":       "false",
 			"only-fn":    "ok",
@@ -101,24 +121,32 @@ func TestEnsureCronJob(t *testing.T) {
 		},
 	}
 
-	clientset := fake.NewSimpleClientset()
+	clientset := testutil.NewApplyClientset()
 
 	pullSecrets := []v1.LocalObjectReference{
 		{Name: "creds"},
 	}
-	err := EnsureCronJob(clientset, f1, cronjobTriggerObj, "unzip", or, pullSecrets)
+	f1Endpoint, err := GetServiceEndpoint(functionService(f1), "", "", "http", "cluster.local")
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	f2Endpoint, err := GetServiceEndpoint(functionService(f2), "", "", "http", "cluster.local")
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
-	err = EnsureCronJob(clientset, f2, cronjobTriggerObj, "unzip", or, pullSecrets)
+	_, _, err = EnsureCronJob(clientset, f1, cronjobTriggerObj, f1Endpoint, "unzip", or, pullSecrets)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
-	cronJob, err := clientset.BatchV1beta1().CronJobs(ns).Get(fmt.Sprintf("trigger-%s", f1.Name), metav1.GetOptions{})
+	_, _, err = EnsureCronJob(clientset, f2, cronjobTriggerObj, f2Endpoint, "unzip", or, pullSecrets)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
-	cronJobCustomPort, err := clientset.BatchV1beta1().CronJobs(ns).Get(fmt.Sprintf("trigger-%s", f2.Name), metav1.GetOptions{})
+	cronJob, err := clientset.BatchV1beta1().CronJobs(ns).Get(context.TODO(), fmt.Sprintf("trigger-%s", f1.Name), metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	cronJobCustomPort, err := clientset.BatchV1beta1().CronJobs(ns).Get(context.TODO(), fmt.Sprintf("trigger-%s", f2.Name), metav1.GetOptions{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
@@ -135,38 +163,42 @@ func TestEnsureCronJob(t *testing.T) {
 		t.Errorf("Unexpected ActiveDeadlineSeconds: %d", *cronJob.Spec.JobTemplate.Spec.ActiveDeadlineSeconds)
 	}
 
-	expectedId := "\"Event-Id: $(POD_UID)\""
-	expectedTime := "\"Event-Time: $(date --rfc-3339=seconds --utc)\""
-	expectedNamespace := "\"Event-Namespace: cronjobtrigger.kubeless.io\""
-	expectedType := "\"Event-Type: application/json\""
-	contentType := "\"Content-Type: application/json\""
 	expectedPortDefault := "8080"
 	expectedPortCustom := "9090"
 
-	expectedHeaders := fmt.Sprintf("-H %s -H %s -H %s -H %s -H %s", expectedId, expectedTime, expectedNamespace, expectedType, contentType)
 	expectedEndpoint := fmt.Sprintf("http://%s.%s.svc.cluster.local:%s", f1Name, ns, expectedPortDefault)
 	expectedEndpointCustomPort := fmt.Sprintf("http://%s.%s.svc.cluster.local:%s", f2Name, ns, expectedPortCustom)
-	expectedCommand := fmt.Sprintf("curl -Lv %s %s", expectedHeaders, expectedEndpoint)
-	expectedCommandCustomPort := fmt.Sprintf("curl -Lv %s %s", expectedHeaders, expectedEndpointCustomPort)
+	expectedRequest := &invoker.Request{
+		URL:            expectedEndpoint,
+		ContentType:    "application/json",
+		TimeoutSeconds: 120,
+		TriggerUID:     "trigger-uid",
+	}
+	expectedRequestCustomPort := &invoker.Request{
+		URL:            expectedEndpointCustomPort,
+		ContentType:    "application/json",
+		TimeoutSeconds: 120,
+		TriggerUID:     "trigger-uid",
+	}
 
 	runtimeContainer := cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0]
 	if runtimeContainer.Image != "unzip" {
 		t.Errorf("Unexpected image %s", runtimeContainer.Image)
 	}
+	if !reflect.DeepEqual(runtimeContainer.Args, []string{"invoke"}) {
+		t.Errorf("Unexpected args %v", runtimeContainer.Args)
+	}
 	runtimeContainerCustomPort := cronJobCustomPort.Spec.JobTemplate.Spec.Template.Spec.Containers[0]
 	if runtimeContainer.Image != "unzip" {
 		t.Errorf("Unexpected image %s", runtimeContainer.Image)
 	}
-	args := runtimeContainer.Args
-	argsCustomPort := runtimeContainerCustomPort.Args
-	// skip event headers data (i.e  -H "event-id: cronjob-controller-2018-03-05T05:55:41.990784027Z" etc)
-	foundCommand := args[0]
-	foundCommandCustomPort := argsCustomPort[0]
-	if !reflect.DeepEqual(foundCommand, expectedCommand) {
-		t.Errorf("Unexpected command %s expexted %s", foundCommand, expectedCommand)
+	foundRequest := getInvocationRequest(t, runtimeContainer)
+	foundRequestCustomPort := getInvocationRequest(t, runtimeContainerCustomPort)
+	if !reflect.DeepEqual(foundRequest, expectedRequest) {
+		t.Errorf("Unexpected request %+v expected %+v", foundRequest, expectedRequest)
 	}
-	if !reflect.DeepEqual(foundCommandCustomPort, expectedCommandCustomPort) {
-		t.Errorf("Unexpected command %s expexted %s", foundCommandCustomPort, expectedCommandCustomPort)
+	if !reflect.DeepEqual(foundRequestCustomPort, expectedRequestCustomPort) {
+		t.Errorf("Unexpected request %+v expected %+v", foundRequestCustomPort, expectedRequestCustomPort)
 	}
 
 	newSchedule = "*/10 * * * *"
@@ -176,31 +208,340 @@ func TestEnsureCronJob(t *testing.T) {
 	cronjobTriggerObj.Spec.Schedule = newSchedule
 	cronjobTriggerObj.Spec.Payload = newData
 
-	err = EnsureCronJob(clientset, f1, cronjobTriggerObj, "unzip", or, pullSecrets)
-	cronJob, err = clientset.BatchV1beta1().CronJobs(ns).Get(fmt.Sprintf("trigger-%s", f1.Name), metav1.GetOptions{})
+	_, _, err = EnsureCronJob(clientset, f1, cronjobTriggerObj, f1Endpoint, "unzip", or, pullSecrets)
+	cronJob, err = clientset.BatchV1beta1().CronJobs(ns).Get(context.TODO(), fmt.Sprintf("trigger-%s", f1.Name), metav1.GetOptions{})
 
 	runtimeContainer = cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0]
-	runtimeContainerCustomPort = cronJobCustomPort.Spec.JobTemplate.Spec.Template.Spec.Containers[0]
-	args = runtimeContainer.Args
-	foundCommand = args[0]
+	foundRequest = getInvocationRequest(t, runtimeContainer)
 
-	expectedData := "-d '{\"test\":\"foo\"}'"
-	expectedCommand = fmt.Sprintf("curl -Lv %s %s %s", expectedHeaders, expectedEndpoint, expectedData)
+	expectedRequest.Payload = "{\"test\":\"foo\"}"
 
-	if !reflect.DeepEqual(foundCommand, expectedCommand) {
-		t.Errorf("Unexpected command %s expected %s", foundCommand, expectedCommand)
+	if !reflect.DeepEqual(foundRequest, expectedRequest) {
+		t.Errorf("Unexpected request %+v expected %+v", foundRequest, expectedRequest)
 	}
 
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
-	updatedCronJob, err := clientset.BatchV1beta1().CronJobs(ns).Get(fmt.Sprintf("trigger-%s", f1.Name), metav1.GetOptions{})
+	updatedCronJob, err := clientset.BatchV1beta1().CronJobs(ns).Get(context.TODO(), fmt.Sprintf("trigger-%s", f1.Name), metav1.GetOptions{})
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err)
 	}
 	if updatedCronJob.Spec.Schedule != newSchedule {
 		t.Errorf("Unexpected schedule %s expecting %s", updatedCronJob.Spec.Schedule, newSchedule)
 	}
+	if !reflect.DeepEqual(expectedMeta.Labels, updatedCronJob.ObjectMeta.Labels) {
+		t.Errorf("Unexpected labels %v expecting %v", updatedCronJob.ObjectMeta.Labels, expectedMeta.Labels)
+	}
+	if !reflect.DeepEqual(expectedMeta.Annotations, updatedCronJob.ObjectMeta.Annotations) {
+		t.Errorf("Unexpected annotations %v expecting %v", updatedCronJob.ObjectMeta.Annotations, expectedMeta.Annotations)
+	}
+
+	// The updated cron job is still recognized as created by the controller
+	_, result, err := EnsureCronJob(clientset, f1, cronjobTriggerObj, f1Endpoint, "unzip", or, pullSecrets)
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if result != OperationResultNone {
+		t.Errorf("Unexpected result %s", result)
+	}
+}
+
+func TestEnsureCronJobRetries(t *testing.T) {
+	ns := "default"
+	f1 := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "func1",
+			Namespace: ns,
+		},
+		Spec: kubelessApi.FunctionSpec{
+			Timeout: "10",
+		},
+	}
+	retry := &cronjobTriggerApi.RetryPolicy{
+		MaxAttempts:          3,
+		InitialBackoff:       &metav1.Duration{Duration: 5 * time.Second},
+		MaxBackoff:           &metav1.Duration{Duration: 20 * time.Second},
+		RetryableStatusCodes: []int32{503},
+	}
+	cronjobTriggerObj := &cronjobTriggerApi.CronJobTrigger{
+		Spec: cronjobTriggerApi.CronJobTriggerSpec{
+			Schedule: "* * * * *",
+			Retry:    retry,
+		},
+	}
+
+	clientset := testutil.NewApplyClientset()
+	_, _, err := EnsureCronJob(clientset, f1, cronjobTriggerObj, "http://func1.default.svc.cluster.local:8080", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	cronJob, err := clientset.BatchV1beta1().CronJobs(ns).Get(context.TODO(), "trigger-func1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	jobSpec := cronJob.Spec.JobTemplate.Spec
+	if *jobSpec.BackoffLimit != 0 {
+		t.Errorf("Unexpected BackoffLimit: %d", *jobSpec.BackoffLimit)
+	}
+	// 3 attempts of 10 seconds plus 5 and 10 seconds of backoff
+	if *jobSpec.ActiveDeadlineSeconds != int64(45) {
+		t.Errorf("Unexpected ActiveDeadlineSeconds: %d", *jobSpec.ActiveDeadlineSeconds)
+	}
+	request := getInvocationRequest(t, jobSpec.Template.Spec.Containers[0])
+	if !reflect.DeepEqual(request.Retry, retry) {
+		t.Errorf("Unexpected retry policy %+v expected %+v", request.Retry, retry)
+	}
+}
+
+func TestGetServiceEndpoint(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "func1",
+			Namespace: "default",
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{Name: "http-function-port", Port: 8080},
+				{Name: "metrics", Port: 9090},
+			},
+		},
+	}
+
+	endpoint, err := GetServiceEndpoint(svc, "", "", "http", "cluster.local")
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if endpoint != "http://func1.default.svc.cluster.local:8080" {
+		t.Errorf("Unexpected endpoint %s", endpoint)
+	}
+
+	endpoint, err = GetServiceEndpoint(svc, "metrics", "", "https", "k8s.example.com")
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if endpoint != "https://func1.default.svc.k8s.example.com:9090" {
+		t.Errorf("Unexpected endpoint %s", endpoint)
+	}
+
+	endpoint, err = GetServiceEndpoint(svc, "8443", "/hooks/nightly", "https", "cluster.local")
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if endpoint != "https://func1.default.svc.cluster.local:8443/hooks/nightly" {
+		t.Errorf("Unexpected endpoint %s", endpoint)
+	}
+
+	_, err = GetServiceEndpoint(svc, "grpc", "", "http", "cluster.local")
+	if err == nil {
+		t.Errorf("Expecting an error for a missing port")
+	}
+}
+
+func TestGetTarget(t *testing.T) {
+	target, err := GetTarget(&cronjobTriggerApi.CronJobTriggerSpec{FunctionName: "foo", FunctionPort: "metrics"})
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	expectedTarget := &cronjobTriggerApi.Target{
+		Function: &cronjobTriggerApi.FunctionTarget{Name: "foo", Port: "metrics"},
+	}
+	if !reflect.DeepEqual(target, expectedTarget) {
+		t.Errorf("Unexpected target %+v expected %+v", target, expectedTarget)
+	}
+
+	serviceTarget := &cronjobTriggerApi.Target{
+		Service: &cronjobTriggerApi.ServiceTarget{Name: "backend", Port: "8080", Path: "/cron"},
+	}
+	target, err = GetTarget(&cronjobTriggerApi.CronJobTriggerSpec{Target: serviceTarget})
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if target != serviceTarget {
+		t.Errorf("Unexpected target %+v expected %+v", target, serviceTarget)
+	}
+
+	target, err = GetTarget(&cronjobTriggerApi.CronJobTriggerSpec{FunctionSelector: &metav1.LabelSelector{}})
+	if err != nil || target != nil {
+		t.Errorf("Unexpected target %+v for a function selector, error: %v", target, err)
+	}
+
+	invalidSpecs := []cronjobTriggerApi.CronJobTriggerSpec{
+		{FunctionName: "foo", FunctionSelector: &metav1.LabelSelector{}},
+		{},
+		{FunctionName: "foo", Target: serviceTarget},
+		{Target: &cronjobTriggerApi.Target{}},
+		{Target: &cronjobTriggerApi.Target{URL: "https://example.com", Service: serviceTarget.Service}},
+		{Target: &cronjobTriggerApi.Target{Function: &cronjobTriggerApi.FunctionTarget{}}},
+		{Target: &cronjobTriggerApi.Target{URL: "ftp://example.com"}},
+	}
+	for _, spec := range invalidSpecs {
+		if _, err := GetTarget(&spec); err == nil {
+			t.Errorf("Expecting an error for %+v", spec)
+		}
+	}
+}
+
+func TestEnsureCronJobWithoutFunction(t *testing.T) {
+	cronjobTriggerObj := &cronjobTriggerApi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "webhook",
+			Namespace: "default",
+			Labels: map[string]string{
+				"team": "ops",
+			},
+		},
+		Spec: cronjobTriggerApi.CronJobTriggerSpec{
+			Schedule: "* * * * *",
+			Target:   &cronjobTriggerApi.Target{URL: "https://example.com/hook"},
+		},
+	}
+
+	clientset := testutil.NewApplyClientset()
+	_, result, err := EnsureCronJob(clientset, nil, cronjobTriggerObj, "https://example.com/hook", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if result != OperationResultCreated {
+		t.Errorf("Expecting the cron job to be created, it was %s", result)
+	}
+	cronJob, err := clientset.BatchV1beta1().CronJobs("default").Get(context.TODO(), GetCronJobName(nil, cronjobTriggerObj), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if cronJob.ObjectMeta.Labels["team"] != "ops" {
+		t.Errorf("Unexpected labels %v", cronJob.ObjectMeta.Labels)
+	}
+	request := getInvocationRequest(t, cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0])
+	if request.URL != "https://example.com/hook" || request.TimeoutSeconds != 180 {
+		t.Errorf("Unexpected request %+v", request)
+	}
+
+	// Updates don't need a function either
+	cronjobTriggerObj.Spec.Schedule = "*/5 * * * *"
+	_, result, err = EnsureCronJob(clientset, nil, cronjobTriggerObj, "https://example.com/hook", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if result != OperationResultUpdated {
+		t.Errorf("Expecting the cron job to be updated, it was %s", result)
+	}
+}
+
+func TestGetURLScheme(t *testing.T) {
+	tests := []struct {
+		tlsConfig      *cronjobTriggerApi.TLSConfig
+		expectedScheme string
+		expectedError  bool
+	}{
+		{tlsConfig: nil, expectedScheme: "http"},
+		{tlsConfig: &cronjobTriggerApi.TLSConfig{}, expectedScheme: "https"},
+		{tlsConfig: &cronjobTriggerApi.TLSConfig{Scheme: "http"}, expectedScheme: "http"},
+		{tlsConfig: &cronjobTriggerApi.TLSConfig{Scheme: "ftp"}, expectedError: true},
+	}
+	for _, test := range tests {
+		scheme, err := GetURLScheme(test.tlsConfig)
+		if (err != nil) != test.expectedError {
+			t.Errorf("Unexpected error for %+v: %v", test.tlsConfig, err)
+		}
+		if scheme != test.expectedScheme {
+			t.Errorf("Unexpected scheme %s for %+v, expecting %s", scheme, test.tlsConfig, test.expectedScheme)
+		}
+	}
+}
+
+func TestEnsureCronJobTLS(t *testing.T) {
+	ns := "default"
+	f1 := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "func1",
+			Namespace: ns,
+		},
+	}
+	cronjobTriggerObj := &cronjobTriggerApi.CronJobTrigger{
+		Spec: cronjobTriggerApi.CronJobTriggerSpec{
+			Schedule: "* * * * *",
+			TLS: &cronjobTriggerApi.TLSConfig{
+				CABundle: &v1.ConfigMapKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{Name: "mesh-ca"},
+					Key:                  "root.pem",
+				},
+				ClientCertSecret: "func1-client",
+				ServerName:       "func1.mesh.local",
+			},
+		},
+	}
+
+	clientset := testutil.NewApplyClientset()
+	_, _, err := EnsureCronJob(clientset, f1, cronjobTriggerObj, "https://func1.default.svc.cluster.local:8080", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	cronJob, err := clientset.BatchV1beta1().CronJobs(ns).Get(context.TODO(), "trigger-func1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	podSpec := cronJob.Spec.JobTemplate.Spec.Template.Spec
+	expectedVolumes := []v1.Volume{
+		{
+			Name: "ca-bundle",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: "mesh-ca"},
+					Items:                []v1.KeyToPath{{Key: "root.pem", Path: "ca.crt"}},
+				},
+			},
+		},
+		{
+			Name: "client-cert",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{SecretName: "func1-client"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(podSpec.Volumes, expectedVolumes) {
+		t.Errorf("Unexpected volumes %+v expected %+v", podSpec.Volumes, expectedVolumes)
+	}
+	if len(podSpec.Containers[0].VolumeMounts) != 2 {
+		t.Errorf("Unexpected volume mounts %+v", podSpec.Containers[0].VolumeMounts)
+	}
+
+	expectedTLS := &invoker.TLSConfig{
+		CAFile:     "/etc/kubeless/tls/ca/ca.crt",
+		CertFile:   "/etc/kubeless/tls/client/tls.crt",
+		KeyFile:    "/etc/kubeless/tls/client/tls.key",
+		ServerName: "func1.mesh.local",
+	}
+	request := getInvocationRequest(t, podSpec.Containers[0])
+	if !reflect.DeepEqual(request.TLS, expectedTLS) {
+		t.Errorf("Unexpected TLS settings %+v expected %+v", request.TLS, expectedTLS)
+	}
+}
+
+func functionService(funcObj *kubelessApi.Function) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      funcObj.ObjectMeta.Name,
+			Namespace: funcObj.ObjectMeta.Namespace,
+		},
+		Spec: funcObj.Spec.ServiceSpec,
+	}
+}
+
+func getInvocationRequest(t *testing.T, container v1.Container) *invoker.Request {
+	for _, env := range container.Env {
+		if env.Name == invoker.RequestEnvVar {
+			request := &invoker.Request{}
+			if err := json.Unmarshal([]byte(env.Value), request); err != nil {
+				t.Fatalf("Unable to parse the invocation request: %s", err)
+			}
+			return request
+		}
+	}
+	t.Fatalf("Missing %s in the container environment", invoker.RequestEnvVar)
+	return nil
 }
 
 func TestAvoidCronjobOverwrite(t *testing.T) {
@@ -223,12 +564,266 @@ func TestAvoidCronjobOverwrite(t *testing.T) {
 
 	clientset := fake.NewSimpleClientset()
 
-	clientset.BatchV1beta1().CronJobs(ns).Create(&batchv1beta1.CronJob{
+	clientset.BatchV1beta1().CronJobs(ns).Create(context.TODO(), &batchv1beta1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("trigger-%s", f1.Name)},
-	})
-	err := EnsureCronJob(clientset, f1, cronjobTriggerObj, "unzip", or, []v1.LocalObjectReference{})
-	if err == nil && strings.Contains(err.Error(), "conflicting object") {
-		t.Errorf("It should fail because a conflict")
+	}, metav1.CreateOptions{})
+	_, _, err := EnsureCronJob(clientset, f1, cronjobTriggerObj, "http://func1.default.svc.cluster.local:8080", "unzip", or, []v1.LocalObjectReference{})
+	if !IsConflict(err) {
+		t.Errorf("It should fail because a conflict, got %v", err)
+	}
+
+	// A cron job of another trigger calling the same function
+	cronjobTriggerObj.ObjectMeta = metav1.ObjectMeta{Name: "nightly", Namespace: ns, UID: "nightly-uid"}
+	clientset.BatchV1beta1().CronJobs(ns).Create(context.TODO(), &batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "trigger-func2",
+			Labels:          map[string]string{"created-by": "kubeless"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "CronJobTrigger", Name: "hourly", UID: "hourly-uid"}},
+		},
+	}, metav1.CreateOptions{})
+	f1.ObjectMeta.Name = "func2"
+	_, _, err = EnsureCronJob(clientset, f1, cronjobTriggerObj, "http://func2.default.svc.cluster.local:8080", "unzip", or, []v1.LocalObjectReference{})
+	if !IsConflict(err) {
+		t.Errorf("It should fail because a conflict, got %v", err)
+	}
+}
+
+func TestGetCronJobName(t *testing.T) {
+	function := &kubelessApi.Function{ObjectMeta: metav1.ObjectMeta{Name: "trigger-foo", Namespace: "default"}}
+	functionTrigger := &cronjobTriggerApi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default", UID: "nightly-uid"},
+	}
+	urlTrigger := &cronjobTriggerApi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default", UID: "foo-uid"},
+	}
+	selectorTrigger := &cronjobTriggerApi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default", UID: "foo-uid"},
+		Spec: cronjobTriggerApi.CronJobTriggerSpec{
+			FunctionSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "ops"}},
+		},
+	}
+
+	functionName := GetCronJobName(function, functionTrigger)
+	if functionName != "trigger-trigger-foo" {
+		t.Errorf("Unexpected name %s", functionName)
+	}
+	// A trigger named after the function calling a URL must not take the cron job of the function
+	urlName := GetCronJobName(nil, urlTrigger)
+	if urlName == functionName || !strings.HasPrefix(urlName, "trigger-foo-") {
+		t.Errorf("Unexpected name %s", urlName)
+	}
+	selectorName := GetCronJobName(function, selectorTrigger)
+	if selectorName != urlName+"-trigger-foo" {
+		t.Errorf("Unexpected name %s", selectorName)
+	}
+	// The names only depend on the UID of the trigger
+	if GetCronJobName(nil, urlTrigger) != urlName {
+		t.Errorf("The name should be stable")
+	}
+	urlTrigger.ObjectMeta.UID = "other-uid"
+	if GetCronJobName(nil, urlTrigger) == urlName {
+		t.Errorf("The name should depend on the UID of the trigger")
+	}
+
+	// Long names are shortened to fit in the name of a CronJob, and still differ by function
+	selectorTrigger.ObjectMeta.Name = "nightly-export-of-the-billing-reports"
+	longFunction := &kubelessApi.Function{ObjectMeta: metav1.ObjectMeta{Name: "billing-reports-export-to-storage-a", Namespace: "default"}}
+	otherFunction := &kubelessApi.Function{ObjectMeta: metav1.ObjectMeta{Name: "billing-reports-export-to-storage-b", Namespace: "default"}}
+	longName := GetCronJobName(longFunction, selectorTrigger)
+	if len(longName) > maxCronJobNameLength || !strings.HasPrefix(longName, "trigger-nightly-export-") {
+		t.Errorf("Unexpected name %s", longName)
+	}
+	if otherName := GetCronJobName(otherFunction, selectorTrigger); otherName == longName || len(otherName) > maxCronJobNameLength {
+		t.Errorf("Unexpected name %s for another function", otherName)
+	}
+	if name := GetCronJobName(longFunction, functionTrigger); len(name) > maxCronJobNameLength {
+		t.Errorf("Unexpected name %s", name)
+	}
+}
+
+func TestEnsureCronJobApplyOptions(t *testing.T) {
+	ns := "default"
+	f1 := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "func1",
+			Namespace: ns,
+		},
+	}
+	cronjobTriggerObj := &cronjobTriggerApi.CronJobTrigger{
+		Spec: cronjobTriggerApi.CronJobTriggerSpec{
+			Schedule: "* * * * *",
+		},
+	}
+	applied := []metav1.ManagedFieldsEntry{{Manager: fieldManager, Operation: metav1.ManagedFieldsOperationApply}}
+	updated := []metav1.ManagedFieldsEntry{{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationUpdate}}
+
+	testCases := []struct {
+		desc          string
+		managedFields []metav1.ManagedFieldsEntry
+		exists        bool
+		conflict      bool
+		expectedForce string
+	}{
+		{desc: "creation", expectedForce: "true"},
+		{desc: "update", exists: true, managedFields: applied, expectedForce: "true"},
+		{desc: "update of a cron job edited outside of the controller", exists: true, managedFields: updated, expectedForce: "true"},
+		{desc: "conflict", exists: true, managedFields: applied, conflict: true, expectedForce: "true"},
+	}
+	for _, tc := range testCases {
+		var query url.Values
+		var contentType string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			cronJob := batchv1beta1.CronJob{
+				TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1beta1", Kind: "CronJob"},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:          "trigger-func1",
+					Namespace:     ns,
+					Labels:        addDefaultLabel(nil),
+					ManagedFields: tc.managedFields,
+				},
+			}
+			var status *k8sErrors.StatusError
+			switch {
+			case r.Method == http.MethodGet && !tc.exists:
+				status = k8sErrors.NewNotFound(batchv1beta1.Resource("cronjobs"), "trigger-func1")
+			case r.Method == http.MethodPatch:
+				query = r.URL.Query()
+				contentType = r.Header.Get("Content-Type")
+				if tc.conflict {
+					status = k8sErrors.NewApplyConflict([]metav1.StatusCause{{Type: metav1.CauseTypeFieldManagerConflict, Message: `conflict with "kubectl"`, Field: ".spec.schedule"}}, "Apply failed with 1 conflict")
+				}
+			}
+			if status != nil {
+				w.WriteHeader(int(status.ErrStatus.Code))
+				json.NewEncoder(w).Encode(status.ErrStatus)
+				return
+			}
+			json.NewEncoder(w).Encode(cronJob)
+		}))
+		clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		_, _, err = EnsureCronJob(clientset, f1, cronjobTriggerObj, "http://func1.default.svc.cluster.local:8080", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{})
+		server.Close()
+		if tc.conflict != IsConflict(err) || (!tc.conflict && err != nil) {
+			t.Errorf("%s: Unexpected error: %v", tc.desc, err)
+		}
+		if contentType != string(types.ApplyPatchType) {
+			t.Errorf("%s: Expecting an apply request, got %q", tc.desc, contentType)
+		}
+		if query.Get("fieldManager") != fieldManager {
+			t.Errorf("%s: Unexpected field manager %q", tc.desc, query.Get("fieldManager"))
+		}
+		if query.Get("force") != tc.expectedForce {
+			t.Errorf("%s: Unexpected force %q", tc.desc, query.Get("force"))
+		}
+	}
+}
+
+func TestEnsureCronJobJobTemplate(t *testing.T) {
+	ns := "default"
+	f1 := &kubelessApi.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "func1",
+			Namespace: ns,
+		},
+	}
+	runAsNonRoot := true
+	cronjobTriggerObj := &cronjobTriggerApi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{Name: "nightly"},
+		Spec: cronjobTriggerApi.CronJobTriggerSpec{
+			Schedule: "* * * * *",
+			JobTemplate: &cronjobTriggerApi.JobTemplate{
+				Labels: map[string]string{
+					"team":       "billing",
+					"created-by": "someone",
+				},
+				Resources: &v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("128Mi")},
+				},
+				NodeSelector:       map[string]string{"pool": "batch"},
+				Tolerations:        []v1.Toleration{{Key: "batch", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}},
+				ServiceAccountName: "invoker",
+				PriorityClassName:  "low",
+				SecurityContext:    &v1.PodSecurityContext{RunAsNonRoot: &runAsNonRoot},
+			},
+		},
+	}
+
+	clientset := testutil.NewApplyClientset()
+	_, _, err := EnsureCronJob(clientset, f1, cronjobTriggerObj, "http://func1.default.svc.cluster.local:8080", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	cronJob, err := clientset.BatchV1beta1().CronJobs(ns).Get(context.TODO(), "trigger-func1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	pod := cronJob.Spec.JobTemplate.Spec.Template
+	// The labels of the controller can't be overridden
+	expectedLabels := map[string]string{"team": "billing", "created-by": "kubeless"}
+	if !reflect.DeepEqual(pod.ObjectMeta.Labels, expectedLabels) {
+		t.Errorf("Unexpected labels %v expecting %v", pod.ObjectMeta.Labels, expectedLabels)
+	}
+	// The default resources are replaced as a whole, the requests of the template can't exceed the default limits
+	expectedResources := v1.ResourceRequirements{
+		Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("128Mi")},
+	}
+	if resources := pod.Spec.Containers[0].Resources; !equality.Semantic.DeepEqual(resources, expectedResources) {
+		t.Errorf("Unexpected resources %v expecting %v", resources, expectedResources)
+	}
+	if !reflect.DeepEqual(pod.Spec.NodeSelector, map[string]string{"pool": "batch"}) {
+		t.Errorf("Unexpected node selector %v", pod.Spec.NodeSelector)
+	}
+	if len(pod.Spec.Tolerations) != 1 || pod.Spec.Tolerations[0].Key != "batch" {
+		t.Errorf("Unexpected tolerations %v", pod.Spec.Tolerations)
+	}
+	if pod.Spec.ServiceAccountName != "invoker" {
+		t.Errorf("Unexpected service account %s", pod.Spec.ServiceAccountName)
+	}
+	if pod.Spec.PriorityClassName != "low" {
+		t.Errorf("Unexpected priority class %s", pod.Spec.PriorityClassName)
+	}
+	if pod.Spec.SecurityContext == nil || !*pod.Spec.SecurityContext.RunAsNonRoot {
+		t.Errorf("Unexpected security context %v", pod.Spec.SecurityContext)
+	}
+	// The jobs are still attributed to the trigger
+	if cronJob.Spec.JobTemplate.ObjectMeta.Labels[TriggerLabel] != "nightly" {
+		t.Errorf("Unexpected job labels %v", cronJob.Spec.JobTemplate.ObjectMeta.Labels)
+	}
+}
+
+func TestMergeJobTemplates(t *testing.T) {
+	defaults := &cronjobTriggerApi.JobTemplate{
+		Labels:             map[string]string{"team": "platform", "tier": "batch"},
+		NodeSelector:       map[string]string{"pool": "batch"},
+		ServiceAccountName: "invoker",
+	}
+	overrides := &cronjobTriggerApi.JobTemplate{
+		Labels:            map[string]string{"team": "billing"},
+		PriorityClassName: "high",
+	}
+	expected := &cronjobTriggerApi.JobTemplate{
+		Labels:             map[string]string{"team": "billing", "tier": "batch"},
+		NodeSelector:       map[string]string{"pool": "batch"},
+		ServiceAccountName: "invoker",
+		PriorityClassName:  "high",
+	}
+	if merged := MergeJobTemplates(defaults, overrides); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Unexpected template %+v expecting %+v", merged, expected)
+	}
+	if merged := MergeJobTemplates(nil, overrides); !reflect.DeepEqual(merged, overrides) {
+		t.Errorf("Unexpected template %+v expecting %+v", merged, overrides)
+	}
+	if merged := MergeJobTemplates(defaults, nil); !reflect.DeepEqual(merged, defaults) {
+		t.Errorf("Unexpected template %+v expecting %+v", merged, defaults)
+	}
+	if defaults.Labels["team"] != "platform" {
+		t.Errorf("The defaults should not be modified")
 	}
 }
 
@@ -254,3 +849,260 @@ func TestMergeMaps(t *testing.T) {
 		t.Errorf("Unexpected merged result: \n Expecting: \n %s \n Received: \n %s", expected, result)
 	}
 }
+
+func TestGetHTTPClient(t *testing.T) {
+	client, err := GetHTTPClient(fake.NewSimpleClientset(), "default", nil)
+	if err != nil || client != http.DefaultClient {
+		t.Errorf("Expecting the default client, received %v (error: %v)", client, err)
+	}
+
+	tlsConfig := &cronjobTriggerApi.TLSConfig{
+		CABundle: &v1.ConfigMapKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: "mesh-ca"},
+			Key:                  "root.pem",
+		},
+	}
+	if _, err := GetHTTPClient(fake.NewSimpleClientset(), "default", tlsConfig); err == nil {
+		t.Errorf("Expecting an error for a missing ConfigMap")
+	}
+
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh-ca", Namespace: "default"},
+		Data:       map[string]string{"ca.crt": "not a certificate"},
+	}
+	if _, err := GetHTTPClient(fake.NewSimpleClientset(configMap), "default", tlsConfig); err == nil {
+		t.Errorf("Expecting an error for a missing key")
+	}
+}
+
+func TestEnsureJob(t *testing.T) {
+	runAt := metav1.NewTime(time.Date(2018, 3, 2, 23, 59, 30, 0, time.UTC))
+	cronjobTriggerObj := &cronjobTriggerApi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "launch",
+			Namespace: "default",
+		},
+		Spec: cronjobTriggerApi.CronJobTriggerSpec{
+			RunAt:  &runAt,
+			Target: &cronjobTriggerApi.Target{URL: "https://example.com/launch"},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	job, err := EnsureJob(clientset, nil, cronjobTriggerObj, "https://example.com/launch", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if job.ObjectMeta.Name != GetCronJobName(nil, cronjobTriggerObj)+"-25333919" {
+		t.Errorf("Unexpected job name %s", job.ObjectMeta.Name)
+	}
+	if job.ObjectMeta.Labels[TriggerLabel] != "launch" || !hasDefaultLabel(job.ObjectMeta.Labels) {
+		t.Errorf("Unexpected job labels %v", job.ObjectMeta.Labels)
+	}
+	request := getInvocationRequest(t, job.Spec.Template.Spec.Containers[0])
+	if request.ScheduledTime == nil || !request.ScheduledTime.Equal(runAt.Time) {
+		t.Errorf("Unexpected scheduled time %v", request.ScheduledTime)
+	}
+	if job.Spec.TTLSecondsAfterFinished == nil || *job.Spec.TTLSecondsAfterFinished != jobTTLSecondsAfterFinished {
+		t.Errorf("Unexpected TTL %v", job.Spec.TTLSecondsAfterFinished)
+	}
+	if finished, _ := JobFinished(job); finished {
+		t.Errorf("The job should not be finished")
+	}
+
+	// The existing job is returned as is
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: v1.ConditionTrue}}
+	clientset.BatchV1().Jobs("default").UpdateStatus(context.TODO(), job, metav1.UpdateOptions{})
+	job, err = EnsureJob(clientset, nil, cronjobTriggerObj, "https://example.com/launch", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if finished, succeeded := JobFinished(job); !finished || !succeeded {
+		t.Errorf("The job should be finished")
+	}
+
+	// Names too long for a label value are shortened in the label, the annotation holds the full name
+	cronjobTriggerObj.ObjectMeta.Name = strings.Repeat("launch-", 12) + "rocket"
+	job, err = EnsureJob(clientset, nil, cronjobTriggerObj, "https://example.com/launch", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if label := job.ObjectMeta.Labels[TriggerLabel]; len(label) > validation.LabelValueMaxLength || !strings.HasPrefix(label, "launch-") {
+		t.Errorf("Unexpected trigger label %s", label)
+	}
+	if name := GetJobTriggerName(job.ObjectMeta); name != cronjobTriggerObj.ObjectMeta.Name {
+		t.Errorf("Unexpected trigger name %s", name)
+	}
+}
+
+func TestEnsureCatchUpJob(t *testing.T) {
+	cronjobTriggerObj := &cronjobTriggerApi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nightly-export-of-the-billing-reports-to-the-storage",
+			Namespace: "default",
+			UID:       "nightly-uid",
+		},
+		Spec: cronjobTriggerApi.CronJobTriggerSpec{
+			Schedule: "0 0 * * *",
+		},
+	}
+	scheduledTime := time.Date(2018, 3, 5, 0, 0, 0, 0, time.UTC)
+
+	clientset := fake.NewSimpleClientset()
+	job, err := EnsureCatchUpJob(clientset, nil, cronjobTriggerObj, "https://example.com/export", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{}, scheduledTime)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// The name of the job is the value of the job-name label of its pods
+	if len(job.ObjectMeta.Name) > validation.LabelValueMaxLength || !strings.HasSuffix(job.ObjectMeta.Name, "-1520208000") {
+		t.Errorf("Unexpected job name %s", job.ObjectMeta.Name)
+	}
+	if scheduled, ok := JobScheduledTime(job); !ok || !scheduled.Equal(scheduledTime) {
+		t.Errorf("Unexpected scheduled time %s", scheduled)
+	}
+	if name := getJobName(strings.Repeat("trigger-", 8), "1520208000"); len(name) > validation.LabelValueMaxLength || !strings.HasSuffix(name, "-1520208000") {
+		t.Errorf("Unexpected job name %s for a long cron job name", name)
+	}
+}
+
+func TestEnsureManualJob(t *testing.T) {
+	cronjobTriggerObj := &cronjobTriggerApi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "report",
+			Namespace:   "default",
+			Annotations: map[string]string{cronjobTriggerApi.RunNowAnnotation: "2018-03-05 test"},
+		},
+		Spec: cronjobTriggerApi.CronJobTriggerSpec{
+			Schedule: "0 0 * * *",
+		},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	job, err := EnsureManualJob(clientset, nil, cronjobTriggerObj, "https://example.com/report", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{}, "2018-03-05 test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(job.ObjectMeta.Name, GetCronJobName(nil, cronjobTriggerObj)+"-manual-") {
+		t.Errorf("Unexpected job name %s", job.ObjectMeta.Name)
+	}
+	if _, ok := job.ObjectMeta.Annotations[cronjobTriggerApi.RunNowAnnotation]; ok {
+		t.Errorf("The run-now annotation should not be copied to the job")
+	}
+
+	// The same token always gives the same job
+	again, err := EnsureManualJob(clientset, nil, cronjobTriggerObj, "https://example.com/report", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{}, "2018-03-05 test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if again.ObjectMeta.Name != job.ObjectMeta.Name {
+		t.Errorf("Unexpected job name %s, expecting %s", again.ObjectMeta.Name, job.ObjectMeta.Name)
+	}
+
+	// The name of the job is the value of the job-name label of its pods
+	cronjobTriggerObj.ObjectMeta.Name = "nightly-export-of-the-billing-reports-to-the-storage"
+	job, err = EnsureManualJob(clientset, nil, cronjobTriggerObj, "https://example.com/report", "unzip", []metav1.OwnerReference{}, []v1.LocalObjectReference{}, "2018-03-05 test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(job.ObjectMeta.Name) > validation.LabelValueMaxLength || !strings.Contains(job.ObjectMeta.Name, "-manual-") {
+		t.Errorf("Unexpected job name %s", job.ObjectMeta.Name)
+	}
+}
+
+func TestGetCalendarExclusions(t *testing.T) {
+	calendar := &cronjobTriggerApi.TriggerCalendar{
+		ObjectMeta: metav1.ObjectMeta{Name: "holidays"},
+		Spec: cronjobTriggerApi.TriggerCalendarSpec{
+			Dates:    []string{"2018-12-25", "2019-01-01"},
+			TimeZone: "Europe/Madrid",
+		},
+	}
+	exclusions, err := GetCalendarExclusions(calendar, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(exclusions) != 2 {
+		t.Fatalf("Unexpected exclusions %v", exclusions)
+	}
+	if !exclusions[0].Start.Time.Equal(time.Date(2018, 12, 24, 23, 0, 0, 0, time.UTC)) || !exclusions[0].End.Time.Equal(time.Date(2018, 12, 25, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected exclusion from %s to %s", exclusions[0].Start, exclusions[0].End)
+	}
+	if exclusions[0].Name != "Excluded by the calendar holidays" {
+		t.Errorf("Unexpected exclusion name %s", exclusions[0].Name)
+	}
+
+	calendar.Spec.Dates = []string{"25/12/2018"}
+	if _, err := GetCalendarExclusions(calendar, ""); err == nil {
+		t.Errorf("Expecting an error for an invalid date")
+	}
+	calendar.Spec.TimeZone = "Nowhere/Unknown"
+	if _, err := GetCalendarExclusions(calendar, ""); err == nil {
+		t.Errorf("Expecting an error for an invalid time zone")
+	}
+}
+
+func TestGetInvocationRequestExclusions(t *testing.T) {
+	start := metav1.NewTime(time.Date(2018, 3, 5, 2, 0, 0, 0, time.UTC))
+	end := metav1.NewTime(time.Date(2018, 3, 5, 4, 0, 0, 0, time.UTC))
+	cronjobTriggerObj := &cronjobTriggerApi.CronJobTrigger{
+		ObjectMeta: metav1.ObjectMeta{Name: "billing", Namespace: "default"},
+		Spec: cronjobTriggerApi.CronJobTriggerSpec{
+			Schedule:   "0 * * * *",
+			Exclusions: []cronjobTriggerApi.Exclusion{{Start: &start, End: &end}},
+		},
+	}
+	request, err := GetInvocationRequest(nil, cronjobTriggerObj, "https://example.com/billing")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []invoker.Exclusion{{Start: start.Time, End: end.Time, Reason: "Excluded from 2018-03-05T02:00:00Z to 2018-03-05T04:00:00Z"}}
+	if !reflect.DeepEqual(request.Exclusions, expected) {
+		t.Errorf("Unexpected exclusions %v", request.Exclusions)
+	}
+
+	for _, exclusion := range []cronjobTriggerApi.Exclusion{{Calendar: "holidays"}, {Start: &start}, {Start: &end, End: &start}} {
+		cronjobTriggerObj.Spec.Exclusions = []cronjobTriggerApi.Exclusion{exclusion}
+		if _, err := GetInvocationRequest(nil, cronjobTriggerObj, "https://example.com/billing"); err == nil {
+			t.Errorf("Expecting an error for the exclusion %+v", exclusion)
+		}
+	}
+}
+
+func TestGetJobResult(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "trigger-nightly-25333919", Namespace: "default"}}
+	finishedAt := metav1.NewTime(time.Date(2018, 3, 5, 5, 59, 0, 0, time.UTC))
+	pods := []runtime.Object{
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "failed", Namespace: "default", Labels: map[string]string{"job-name": job.Name}},
+			Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{FinishedAt: finishedAt, Message: "panic: out of memory"}},
+			}}},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "succeeded", Namespace: "default", Labels: map[string]string{"job-name": job.Name}},
+			Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{FinishedAt: finishedAt, Message: `{"statusCode":200,"attempts":2}`}},
+			}}},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", Labels: map[string]string{"job-name": "other"}},
+			Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{FinishedAt: finishedAt, Message: `{"statusCode":500,"attempts":1}`}},
+			}}},
+		},
+	}
+	clientset := fake.NewSimpleClientset(pods...)
+
+	result, err := GetJobResult(clientset, job)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result == nil || result.StatusCode != 200 || result.Attempts != 2 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	result, err = GetJobResult(clientset, &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default"}})
+	if err != nil || result != nil {
+		t.Errorf("Unexpected result %+v (error: %v)", result, err)
+	}
+}